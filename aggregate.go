@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AggregateFunc is an aggregate function that can be computed on each partition and
+// merged into the result for all partitions.
+type AggregateFunc string
+
+const (
+	AggregateCount AggregateFunc = "COUNT"
+	AggregateSum   AggregateFunc = "SUM"
+	AggregateMin   AggregateFunc = "MIN"
+	AggregateMax   AggregateFunc = "MAX"
+	// AggregateAvg is computed from the SUM and COUNT of each partition, since the
+	// average of the partition averages is wrong when partitions hold different
+	// numbers of rows.
+	AggregateAvg AggregateFunc = "AVG"
+)
+
+// Aggregate is one aggregate column of an AggregateQuery.
+type Aggregate struct {
+	Func AggregateFunc
+	// Expr is the expression aggregated, e.g. "e.student_id", or "*" for COUNT(*).
+	// DISTINCT is not supported: a value found in two partitions would be counted twice.
+	Expr string
+	// Alias names the column in OrderBy.
+	Alias string
+}
+
+// OrderTerm sorts the rows of an AggregateQuery by a GROUP BY expression or an
+// aggregate alias.
+type OrderTerm struct {
+	Column string
+	Desc   bool
+}
+
+// AggregateQuery is a GROUP BY query over data spread across the partitions. Each
+// partition computes partial aggregates for its own rows, and the partial results are
+// merged group by group. Ordering and the limit are applied after the merge, since a
+// group's total is only known once every partition has reported its share. When the
+// rows are only ordered by GROUP BY expressions, the limit is also pushed down to the
+// partitions, see runAggregate.
+type AggregateQuery struct {
+	// From is the FROM clause, including any joins.
+	From string
+	// Where is an optional WHERE clause, with ? placeholders for Args.
+	Where string
+	Args  []interface{}
+	// GroupBy lists the expressions rows are grouped by.
+	GroupBy    []string
+	Aggregates []Aggregate
+	OrderBy    []OrderTerm
+	// Limit caps the number of merged rows returned; zero returns every row.
+	Limit int
+}
+
+// AggregateRow is a row of a merged AggregateQuery result.
+type AggregateRow struct {
+	// Groups holds the GROUP BY values, in the order of AggregateQuery.GroupBy.
+	Groups []interface{}
+	// Values holds the aggregates, in the order of AggregateQuery.Aggregates. COUNT is
+	// an int64, AVG a float64, and SUM an int64 unless a partition returned a float64.
+	// SUM, MIN, MAX and AVG are nil for a group without non-NULL values.
+	Values []interface{}
+}
+
+// aggregateState accumulates the partial results of one aggregate for one group.
+type aggregateState struct {
+	count    int64
+	intSum   int64
+	floatSum float64
+	isFloat  bool
+	value    interface{}
+	valid    bool
+}
+
+// runAggregate runs q on every partition and merges the partial results. Partitions
+// that are unavailable are skipped; the rows merged from the remaining partitions
+// are returned along with a *PartialResultError.
+//
+// When the rows are only ordered by GROUP BY expressions, each sqlite partition returns
+// just its first Limit groups in that order: a group among the first Limit merged rows
+// is among the first Limit groups of every partition with rows for it. PostgreSQL
+// sorts NULLs and text differently from compareValues, so its partitions return every
+// group. When the rows are ordered by an aggregate, a group's place is only known after
+// the merge, so every partition returns all of its groups, and the cost of the query
+// grows with the number of groups rather than with Limit.
+func runAggregate(q AggregateQuery) ([]AggregateRow, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := q.partitionSQL(0)
+	limited := query
+	if q.Limit > 0 && q.orderedByGroups() {
+		limited = q.partitionSQL(q.Limit)
+	}
+	groups := make(map[string]*AggregateRow)
+	states := make(map[string][]aggregateState)
+	var keys []string
+	var partial *PartialResultError
+	for i := range pm.DBs {
+		partitionQuery := query
+		if pm.DBs[i].Dialect == SQLiteDialect {
+			partitionQuery = limited
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := q.mergePartition(ctx, pm.DBs[i], partitionQuery, func(key string, g []interface{}, partials []interface{}) {
+			if _, ok := groups[key]; !ok {
+				groups[key] = &AggregateRow{Groups: g}
+				states[key] = make([]aggregateState, len(q.Aggregates))
+				keys = append(keys, key)
+			}
+			q.merge(states[key], partials)
+		})
+		cancel()
+		if isPartitionUnavailable(err) {
+			partial = partial.skip(pm.DBs[i], err)
+			continue
+		}
+		if err != nil {
+			return nil, wrapError(pm.DBs[i], "run aggregate query", err)
+		}
+	}
+
+	rows := make([]AggregateRow, 0, len(keys))
+	for _, key := range keys {
+		row := groups[key]
+		row.Values = q.values(states[key])
+		rows = append(rows, *row)
+	}
+	q.sort(rows)
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+
+	if partial != nil {
+		return rows, partial
+	}
+	return rows, nil
+}
+
+func (q AggregateQuery) validate() error {
+	if len(q.Aggregates) == 0 {
+		return fmt.Errorf("Unable to run aggregate query: no aggregates")
+	}
+	columns := make(map[string]bool)
+	for _, g := range q.GroupBy {
+		columns[g] = true
+	}
+	for _, a := range q.Aggregates {
+		switch a.Func {
+		case AggregateCount, AggregateSum, AggregateMin, AggregateMax, AggregateAvg:
+		default:
+			return fmt.Errorf("Unable to run aggregate query: unsupported function %q", a.Func)
+		}
+		if a.Alias == "" || columns[a.Alias] {
+			return fmt.Errorf("Unable to run aggregate query: %s(%s) needs a unique alias", a.Func, a.Expr)
+		}
+		columns[a.Alias] = true
+	}
+	for _, o := range q.OrderBy {
+		if !columns[o.Column] {
+			return fmt.Errorf("Unable to run aggregate query: cannot order by %q, it is not grouped or aggregated", o.Column)
+		}
+	}
+	return nil
+}
+
+// orderedByGroups reports whether the query has GROUP BY expressions and is only
+// ordered by them, so the order of a group does not depend on its aggregates.
+func (q AggregateQuery) orderedByGroups() bool {
+	if len(q.GroupBy) == 0 {
+		return false
+	}
+	grouped := make(map[string]bool)
+	for _, g := range q.GroupBy {
+		grouped[g] = true
+	}
+	for _, o := range q.OrderBy {
+		if !grouped[o.Column] {
+			return false
+		}
+	}
+	return true
+}
+
+// partitionSQL returns the query each partition runs: the GROUP BY expressions
+// followed by the partial aggregates, with SUM and COUNT in place of AVG. If limit
+// is positive, the partition only returns its first limit groups, in the order sort
+// puts them in; see runAggregate.
+func (q AggregateQuery) partitionSQL(limit int) string {
+	columns := append([]string{}, q.GroupBy...)
+	for _, a := range q.Aggregates {
+		if a.Func == AggregateAvg {
+			columns = append(columns, fmt.Sprintf("SUM(%s)", a.Expr), fmt.Sprintf("COUNT(%s)", a.Expr))
+			continue
+		}
+		columns = append(columns, fmt.Sprintf("%s(%s)", a.Func, a.Expr))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), q.From)
+	if q.Where != "" {
+		query += " WHERE " + q.Where
+	}
+	if len(q.GroupBy) > 0 {
+		query += " GROUP BY " + strings.Join(q.GroupBy, ", ")
+	}
+	if limit > 0 {
+		var order []string
+		for _, o := range q.OrderBy {
+			if o.Desc {
+				order = append(order, o.Column+" DESC")
+			} else {
+				order = append(order, o.Column)
+			}
+		}
+		order = append(order, q.GroupBy...)
+		query += fmt.Sprintf(" ORDER BY %s LIMIT %d", strings.Join(order, ", "), limit)
+	}
+	return query
+}
+
+// mergePartition runs the partition query and passes each group's values and partial
+// aggregates to fn.
+func (q AggregateQuery) mergePartition(ctx context.Context, partition *Database, query string, fn func(key string, groups []interface{}, partials []interface{})) error {
+	rows, err := partition.queryReader(ctx, query, q.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range values {
+			b, ok := v.([]byte)
+			if !ok {
+				continue
+			}
+			values[i] = string(b)
+			// PostgreSQL returns NUMERIC sums and averages as text
+			if i >= len(q.GroupBy) {
+				if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+					values[i] = n
+				} else if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+					values[i] = f
+				}
+			}
+		}
+
+		groups := values[:len(q.GroupBy)]
+		fn(fmt.Sprintf("%#v", groups), groups, values[len(q.GroupBy):])
+	}
+	return rows.Err()
+}
+
+// merge folds a partition's partial aggregates for a group into states.
+func (q AggregateQuery) merge(states []aggregateState, partials []interface{}) {
+	k := 0
+	for i, a := range q.Aggregates {
+		s := &states[i]
+		v := partials[k]
+		k++
+
+		switch a.Func {
+		case AggregateCount:
+			s.count += toInt64(v)
+		case AggregateSum, AggregateAvg:
+			if v != nil {
+				s.valid = true
+				if f, ok := v.(float64); ok {
+					s.isFloat = true
+					s.floatSum += f
+				} else {
+					s.intSum += toInt64(v)
+				}
+			}
+			if a.Func == AggregateAvg {
+				s.count += toInt64(partials[k])
+				k++
+			}
+		case AggregateMin, AggregateMax:
+			if v == nil {
+				continue
+			}
+			c := compareValues(v, s.value)
+			if !s.valid || (a.Func == AggregateMin && c < 0) || (a.Func == AggregateMax && c > 0) {
+				s.value = v
+				s.valid = true
+			}
+		}
+	}
+}
+
+// values returns the final value of each aggregate.
+func (q AggregateQuery) values(states []aggregateState) []interface{} {
+	values := make([]interface{}, len(q.Aggregates))
+	for i, a := range q.Aggregates {
+		s := states[i]
+		sum := float64(s.intSum) + s.floatSum
+
+		switch a.Func {
+		case AggregateCount:
+			values[i] = s.count
+		case AggregateSum:
+			if !s.valid {
+				continue
+			}
+			if s.isFloat {
+				values[i] = sum
+			} else {
+				values[i] = s.intSum
+			}
+		case AggregateAvg:
+			if s.valid && s.count > 0 {
+				values[i] = sum / float64(s.count)
+			}
+		case AggregateMin, AggregateMax:
+			values[i] = s.value
+		}
+	}
+	return values
+}
+
+// sort orders rows by OrderBy, then by the GROUP BY values so the order is stable.
+func (q AggregateQuery) sort(rows []AggregateRow) {
+	index := make(map[string]func(r AggregateRow) interface{})
+	for i, g := range q.GroupBy {
+		i := i
+		index[g] = func(r AggregateRow) interface{} { return r.Groups[i] }
+	}
+	for i, a := range q.Aggregates {
+		i := i
+		index[a.Alias] = func(r AggregateRow) interface{} { return r.Values[i] }
+	}
+
+	sort.SliceStable(rows, func(x, y int) bool {
+		for _, o := range q.OrderBy {
+			c := compareValues(index[o.Column](rows[x]), index[o.Column](rows[y]))
+			if c != 0 {
+				return (c < 0) != o.Desc
+			}
+		}
+		for i := range q.GroupBy {
+			if c := compareValues(rows[x].Groups[i], rows[y].Groups[i]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// toInt64 converts an integer column value; anything else is 0.
+func toInt64(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// compareValues orders column values: NULL first, then numbers, strings and times by
+// value. Values of other types are compared as text.
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	if aNum && bNum {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	if at, ok := a.(time.Time); ok {
+		if bt, ok := b.(time.Time); ok {
+			switch {
+			case at.Before(bt):
+				return -1
+			case at.After(bt):
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// CourseEnrollmentCount is the number of students enrolled in a course.
+type CourseEnrollmentCount struct {
+	CourseCode string `json:"course_code"`
+	Enrolled   int    `json:"enrolled"`
+}
+
+// getEnrollmentCountsByCourse counts the students enrolled in each course across every
+// partition, in a term or, if termCode is empty, in every term. Courses are ordered by
+// the number of students, most first, and limit caps how many are returned; zero
+// returns every course with at least one student.
+func getEnrollmentCountsByCourse(termCode string, limit int) ([]CourseEnrollmentCount, error) {
+	q := AggregateQuery{
+		From:       "enrollment",
+		GroupBy:    []string{"course_code"},
+		Aggregates: []Aggregate{{Func: AggregateCount, Expr: "*", Alias: "enrolled"}},
+		OrderBy:    []OrderTerm{{Column: "enrolled", Desc: true}},
+		Limit:      limit,
+	}
+	if termCode != "" {
+		q.Where = "term_code = ?"
+		q.Args = []interface{}{termCode}
+	}
+
+	rows, err := runAggregate(q)
+	counts := make([]CourseEnrollmentCount, len(rows))
+	for i, r := range rows {
+		counts[i] = CourseEnrollmentCount{CourseCode: fmt.Sprint(r.Groups[0]), Enrolled: int(toInt64(r.Values[0]))}
+	}
+	return counts, err
+}
+
+// TermEnrollmentSummary summarizes the enrollments in a term.
+type TermEnrollmentSummary struct {
+	TermCode string `json:"term_code"`
+	// Enrollments is the number of course enrollments.
+	Enrollments int `json:"enrollments"`
+	// AverageGradePoints is the mean grade point of the graded enrollments, or zero if
+	// none are graded yet.
+	AverageGradePoints float64   `json:"average_grade_points"`
+	FirstEnrolled      time.Time `json:"first_enrolled"`
+	LastEnrolled       time.Time `json:"last_enrolled"`
+}
+
+// gradePointsSQL maps final_grade to grade points; grades that are not letter grades
+// are NULL, so they are left out of the average.
+const gradePointsSQL = `CASE final_grade
+	WHEN 'A+' THEN 4.0 WHEN 'A' THEN 4.0 WHEN 'A-' THEN 3.7
+	WHEN 'B+' THEN 3.3 WHEN 'B' THEN 3.0 WHEN 'B-' THEN 2.7
+	WHEN 'C+' THEN 2.3 WHEN 'C' THEN 2.0 WHEN 'C-' THEN 1.7
+	WHEN 'D+' THEN 1.3 WHEN 'D' THEN 1.0 WHEN 'D-' THEN 0.7
+	WHEN 'F' THEN 0.0 END`
+
+// getTermEnrollmentSummaries summarizes the enrollments in each term across every
+// partition, ordered by term code.
+func getTermEnrollmentSummaries() ([]TermEnrollmentSummary, error) {
+	rows, err := runAggregate(AggregateQuery{
+		From:    "enrollment",
+		GroupBy: []string{"term_code"},
+		Aggregates: []Aggregate{
+			{Func: AggregateCount, Expr: "*", Alias: "enrollments"},
+			{Func: AggregateAvg, Expr: gradePointsSQL, Alias: "average_grade_points"},
+			{Func: AggregateMin, Expr: "date_enrolled", Alias: "first_enrolled"},
+			{Func: AggregateMax, Expr: "date_enrolled", Alias: "last_enrolled"},
+		},
+		OrderBy: []OrderTerm{{Column: "term_code"}},
+	})
+
+	summaries := make([]TermEnrollmentSummary, len(rows))
+	for i, r := range rows {
+		s := TermEnrollmentSummary{
+			TermCode:      fmt.Sprint(r.Groups[0]),
+			Enrollments:   int(toInt64(r.Values[0])),
+			FirstEnrolled: time.Unix(toInt64(r.Values[2]), 0).UTC(),
+			LastEnrolled:  time.Unix(toInt64(r.Values[3]), 0).UTC(),
+		}
+		if avg, ok := r.Values[1].(float64); ok {
+			s.AverageGradePoints = avg
+		}
+		summaries[i] = s
+	}
+	return summaries, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestAggregates
+// Run sub test:  	go test -run TestAggregates/TestAverageIsWeighted
+func TestAggregates(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	db := addTestCourse(t, Course{"DB101", "Databases 101", 0})
+	algo := addTestCourse(t, Course{"ALGO201", "Algorithms 201", 0})
+	// Ken Thompson and Ian Taylor are in the first partition, the others in the second
+	students, err := addStudents([]Student{
+		{Name: "Ken Thompson", Mobile: "8885551112"},
+		{Name: "Ian Taylor", Mobile: "8885551115"},
+		{Name: "Rob Pike", Mobile: "8885551111"},
+		{Name: "Russ Cox", Mobile: "8885551114"},
+	})
+	if err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+	grades := map[string]string{"Ken Thompson": "A", "Rob Pike": "B", "Russ Cox": "C"}
+	for _, s := range students {
+		course := db
+		if s.Name == "Ian Taylor" {
+			course = algo
+		}
+		if _, err := enrollStudent(s, testTerm, []Course{course}); err != nil {
+			t.Fatalf("Expected %s to be enrolled, received error: %v", s.Name, err)
+		}
+		if grade, ok := grades[s.Name]; ok {
+			if err := setFinalGrade(s, testTerm, course.CourseCode, grade); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// TESTS //
+	t.Run("TestCountsMergedAcrossPartitions", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		counts, err := getEnrollmentCountsByCourse("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []CourseEnrollmentCount{{"DB101", 3}, {"ALGO201", 1}}
+		if fmt.Sprint(counts) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, counts)
+		}
+
+		counts, err = getEnrollmentCountsByCourse(testTerm.Code, 1)
+		if err != nil || len(counts) != 1 || counts[0].CourseCode != "DB101" {
+			t.Errorf("Expected only DB101, received: %v, error: %v", counts, err)
+		}
+		counts, err = getEnrollmentCountsByCourse("2022SP", 0)
+		if err != nil || len(counts) != 0 {
+			t.Errorf("Expected no courses in a term without enrollments, received: %v, error: %v", counts, err)
+		}
+	})
+
+	t.Run("TestAverageIsWeighted", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		summaries, err := getTermEnrollmentSummaries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != 1 || summaries[0].Enrollments != 4 {
+			t.Fatalf("Expected 4 enrollments in %s, received: %+v", testTerm.Code, summaries)
+		}
+		// the partition averages are 4.0 and 2.5; averaging those would give 3.25
+		if math.Abs(summaries[0].AverageGradePoints-3.0) > 1e-9 {
+			t.Errorf("Expected an average of 3.0 grade points, received: %v", summaries[0].AverageGradePoints)
+		}
+		if summaries[0].FirstEnrolled.After(summaries[0].LastEnrolled) || time.Since(summaries[0].LastEnrolled) > time.Minute {
+			t.Errorf("Expected first and last enrollment times, received: %+v", summaries[0])
+		}
+	})
+
+	t.Run("TestMinMaxAndOrder", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		rows, err := runAggregate(AggregateQuery{
+			From:    "enrollment AS e JOIN students AS s ON e.student_id = s.id",
+			GroupBy: []string{"e.course_code"},
+			Aggregates: []Aggregate{
+				{Func: AggregateMin, Expr: "s.name", Alias: "first"},
+				{Func: AggregateMax, Expr: "s.name", Alias: "last"},
+				{Func: AggregateSum, Expr: "1", Alias: "total"},
+			},
+			OrderBy: []OrderTerm{{Column: "e.course_code"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []AggregateRow{
+			{Groups: []interface{}{"ALGO201"}, Values: []interface{}{"Ian Taylor", "Ian Taylor", int64(1)}},
+			{Groups: []interface{}{"DB101"}, Values: []interface{}{"Ken Thompson", "Russ Cox", int64(3)}},
+		}
+		if fmt.Sprintf("%#v", rows) != fmt.Sprintf("%#v", want) {
+			t.Errorf("Expected %v, received: %v", want, rows)
+		}
+	})
+
+	t.Run("TestInvalidQuery", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := []AggregateQuery{
+			{From: "enrollment"},
+			{From: "enrollment", Aggregates: []Aggregate{{Func: "MEDIAN", Expr: "date_enrolled", Alias: "m"}}},
+			{From: "enrollment", Aggregates: []Aggregate{{Func: AggregateCount, Expr: "*"}}},
+			{From: "enrollment", Aggregates: []Aggregate{{Func: AggregateCount, Expr: "*", Alias: "n"}}, OrderBy: []OrderTerm{{Column: "course_code"}}},
+		}
+		for _, q := range tests {
+			if _, err := runAggregate(q); err == nil {
+				t.Errorf("Expected %+v to be rejected, received nil", q)
+			}
+		}
+	})
+
+	t.Run("TestPartialResults", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		pm, release := topology.Acquire()
+		release()
+		second := pm.DBs[1]
+		second.ConfigureCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1})
+		defer second.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		second.withBreaker(func() error { return context.DeadlineExceeded })
+
+		counts, err := getEnrollmentCountsByCourse("", 0)
+		var partial *PartialResultError
+		if !errors.As(err, &partial) {
+			t.Fatalf("Expected a *PartialResultError, received: %v", err)
+		}
+		want := []CourseEnrollmentCount{{"ALGO201", 1}, {"DB101", 1}}
+		if fmt.Sprint(counts) != fmt.Sprint(want) {
+			t.Errorf("Expected counts from the available partition %v, received: %v", want, counts)
+		}
+	})
+
+	t.Run("TestLimitPushedDownWhenOrderedByGroups", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		q := AggregateQuery{
+			From:       "enrollment",
+			GroupBy:    []string{"course_code"},
+			Aggregates: []Aggregate{{Func: AggregateCount, Expr: "*", Alias: "enrolled"}},
+			Limit:      2,
+		}
+		if want := "ORDER BY course_code LIMIT 2"; !strings.HasSuffix(q.partitionSQL(q.Limit), want) || !q.orderedByGroups() {
+			t.Errorf("Expected the partition query to end with %q, received: %s", want, q.partitionSQL(q.Limit))
+		}
+
+		// the second partition now has AAA101, ALGO201 and DB101, and the first ALGO201
+		// and DB101, so each partition drops a group but ALGO201 is counted on both
+		aaa := addTestCourse(t, Course{"AAA101", "Introductions 101", 0})
+		niklaus, err := addStudent(Student{Name: "Niklaus Wirth", Mobile: "8885551116"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enrollStudent(niklaus, testTerm, []Course{aaa, algo}); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := runAggregate(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []AggregateRow{
+			{Groups: []interface{}{"AAA101"}, Values: []interface{}{int64(1)}},
+			{Groups: []interface{}{"ALGO201"}, Values: []interface{}{int64(2)}},
+		}
+		if fmt.Sprintf("%#v", rows) != fmt.Sprintf("%#v", want) {
+			t.Errorf("Expected %v, received: %v", want, rows)
+		}
+
+		q.OrderBy = []OrderTerm{{Column: "enrolled", Desc: true}}
+		if q.orderedByGroups() {
+			t.Error("Expected a query ordered by an aggregate not to push the limit down")
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrCircuitOpen is returned when a partition's circuit breaker is open and the
+// request was rejected without being sent to the partition.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitState is the state of a partition's circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request until the open timeout has passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial requests through to find out
+	// whether the partition has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitBreakerConfig controls when a partition's circuit breaker opens and closes.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests that opens the circuit.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before trial requests are let through.
+	OpenTimeout time.Duration
+	// HalfOpenMaxRequests is the number of trial requests allowed at once while the
+	// circuit is half-open. That many successful trials close the circuit again, and
+	// a single failed trial opens it.
+	HalfOpenMaxRequests int
+}
+
+// DefaultCircuitBreakerConfig is used for partitions that do not configure a circuit breaker.
+var DefaultCircuitBreakerConfig = CircuitBreakerConfig{
+	FailureThreshold:    5,
+	OpenTimeout:         10 * time.Second,
+	HalfOpenMaxRequests: 1,
+}
+
+// circuitBreaker stops sending requests to a partition that keeps failing, so
+// callers get an error immediately instead of each waiting out its own timeout.
+type circuitBreaker struct {
+	mu             sync.Mutex
+	name           string
+	cfg            CircuitBreakerConfig
+	state          CircuitState
+	failures       int
+	openedAt       time.Time
+	trials         int
+	trialSuccesses int
+}
+
+// allow reports whether a request may be sent to the partition. trial is true for
+// requests let through while the circuit is half-open and must be passed to record.
+func (b *circuitBreaker) allow() (trial bool, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return false, false
+		}
+		b.setState(CircuitHalfOpen)
+		b.trials = 0
+		b.trialSuccesses = 0
+		fallthrough
+	case CircuitHalfOpen:
+		if b.trials >= b.cfg.HalfOpenMaxRequests {
+			return false, false
+		}
+		b.trials++
+		return true, true
+	}
+	return false, true
+}
+
+// record applies the outcome of a request that allow let through.
+func (b *circuitBreaker) record(trial bool, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case trial && b.state == CircuitHalfOpen:
+		b.trials--
+		if failed {
+			b.open()
+			return
+		}
+		b.trialSuccesses++
+		if b.trialSuccesses >= b.cfg.HalfOpenMaxRequests {
+			b.failures = 0
+			b.setState(CircuitClosed)
+		}
+	case !trial && b.state == CircuitClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.cfg.FailureThreshold {
+			b.open()
+		}
+	}
+	// requests that finish after the circuit changed state say nothing about the
+	// current state, so they are ignored
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(CircuitOpen)
+}
+
+func (b *circuitBreaker) setState(s CircuitState) {
+	if b.state != s {
+		log.Printf("Circuit breaker for partition %s is %s", b.name, s)
+	}
+	b.state = s
+}
+
+// rejecting reports whether the circuit is open and not yet ready for a trial request.
+func (b *circuitBreaker) rejecting() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == CircuitOpen && time.Since(b.openedAt) < b.cfg.OpenTimeout
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// isPartitionFailure reports whether err means the partition itself is unhealthy
+// (timed out, locked, or unreadable), as opposed to the request being invalid.
+// Only partition failures count towards opening the circuit.
+func isPartitionFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || isBusyError(err) {
+		return true
+	}
+
+	if code, ok := sqliteErrorCode(err); ok {
+		switch code {
+		case sqliteIOErr, sqliteCorrupt, sqliteFull, sqliteCantOpen, sqliteNotADB:
+			return true
+		}
+	}
+
+	// connection exceptions, insufficient resources and server shutdown
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", "53", "57":
+			return true
+		}
+	}
+	return false
+}
+
+// isPartitionUnavailable reports whether err means the partition could not serve
+// the request at all, so cross-partition reads can skip it and return what the
+// other partitions have.
+func isPartitionUnavailable(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrPartitionUnavailable) || isPartitionFailure(err)
+}
+
+// ConfigureCircuitBreaker replaces the partition's circuit breaker settings and
+// closes the circuit. Zero values fall back to DefaultCircuitBreakerConfig.
+func (i *Database) ConfigureCircuitBreaker(c CircuitBreakerConfig) {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = DefaultCircuitBreakerConfig.FailureThreshold
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = DefaultCircuitBreakerConfig.OpenTimeout
+	}
+	if c.HalfOpenMaxRequests <= 0 {
+		c.HalfOpenMaxRequests = DefaultCircuitBreakerConfig.HalfOpenMaxRequests
+	}
+
+	i.breaker.mu.Lock()
+	defer i.breaker.mu.Unlock()
+	i.breaker.name = i.Name
+	i.breaker.cfg = c
+	i.breaker.state = CircuitClosed
+	i.breaker.failures = 0
+}
+
+// CircuitState returns the current state of the partition's circuit breaker.
+func (i *Database) CircuitState() CircuitState {
+	return i.breaker.currentState()
+}
+
+// withBreaker runs fn against the partition's primary unless the circuit is open,
+// in which case it fails immediately with ErrCircuitOpen.
+func (i *Database) withBreaker(fn func() error) error {
+	trial, ok := i.breaker.allow()
+	if !ok {
+		return i.circuitOpenError()
+	}
+
+	err := fn()
+	i.breaker.record(trial, isPartitionFailure(err))
+	return err
+}
+
+// circuitOpenError wraps ErrCircuitOpen with the partition name.
+func (i *Database) circuitOpenError() error {
+	return &PartitionError{Partition: i.Name, Err: ErrCircuitOpen}
+}
+
+// query runs a read query on the partition's primary through the circuit breaker,
+// translating its placeholders for the partition's dialect.
+// Use it for reads that must see the partition's latest writes; reads that tolerate
+// replication lag should use queryReader.
+func (i *Database) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := i.withBreaker(func() error {
+		var err error
+		rows, err = i.primaryReader().QueryContext(ctx, i.rebind(query), args...)
+		return err
+	})
+	return rows, err
+}
+
+// PartialResultError is returned by functions that read from every partition when
+// some partitions could not be reached. The results from the partitions that did
+// respond are returned alongside it.
+type PartialResultError struct {
+	// Failed maps the name of each partition that was skipped to the reason.
+	Failed map[string]error
+}
+
+func (e *PartialResultError) Error() string {
+	names := make([]string, 0, len(e.Failed))
+	for name := range e.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reasons := make([]string, len(names))
+	for k, name := range names {
+		reasons[k] = e.Failed[name].Error()
+	}
+	return fmt.Sprintf("Partial results, unable to read from %d partition(s): %s", len(names), strings.Join(reasons, "; "))
+}
+
+// skip records that a partition was skipped and returns the error to report once
+// every partition has been read.
+func (e *PartialResultError) skip(partition *Database, err error) *PartialResultError {
+	if e == nil {
+		e = &PartialResultError{Failed: make(map[string]error)}
+	}
+	log.Printf("Skipping partition %s: %v", partition.Name, err)
+	e.Failed[partition.Name] = err
+	return e
+}
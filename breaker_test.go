@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestCircuitBreaker
+// Run sub test:  	go test -run TestCircuitBreaker/TestOpensAfterConsecutiveFailures
+func TestCircuitBreaker(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	pm, release := topology.Acquire()
+	release()
+	db := pm.DBs[1]
+	cfg := CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond, HalfOpenMaxRequests: 1}
+	fail := func() error { return context.DeadlineExceeded }
+	succeed := func() error { return nil }
+
+	// TESTS //
+	t.Run("TestOpensAfterConsecutiveFailures", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.ConfigureCircuitBreaker(cfg)
+		defer db.ConfigureCircuitBreaker(cfg)
+
+		db.withBreaker(fail)
+		db.withBreaker(succeed)
+		db.withBreaker(fail)
+		if db.CircuitState() != CircuitClosed {
+			t.Fatalf("Expected a success to reset the failure count, circuit is %s", db.CircuitState())
+		}
+
+		db.withBreaker(fail)
+		if db.CircuitState() != CircuitOpen {
+			t.Fatalf("Expected circuit to open, circuit is %s", db.CircuitState())
+		}
+
+		called := false
+		err := db.withBreaker(func() error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, ErrCircuitOpen) || called {
+			t.Errorf("Expected request to be rejected with ErrCircuitOpen, received: %v", err)
+		}
+	})
+
+	t.Run("TestIgnoresRequestErrors", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.ConfigureCircuitBreaker(cfg)
+
+		for k := 0; k < 5; k++ {
+			db.withBreaker(func() error { return sql.ErrNoRows })
+		}
+		if db.CircuitState() != CircuitClosed {
+			t.Errorf("Expected errors caused by the request to leave the circuit closed, circuit is %s", db.CircuitState())
+		}
+	})
+
+	t.Run("TestHalfOpenTrial", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.ConfigureCircuitBreaker(cfg)
+		defer db.ConfigureCircuitBreaker(cfg)
+
+		db.withBreaker(fail)
+		db.withBreaker(fail)
+		time.Sleep(cfg.OpenTimeout)
+
+		// a failed trial opens the circuit again
+		if err := db.withBreaker(fail); errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("Expected a trial request to be let through after the open timeout")
+		}
+		if db.CircuitState() != CircuitOpen {
+			t.Fatalf("Expected failed trial to reopen the circuit, circuit is %s", db.CircuitState())
+		}
+
+		time.Sleep(cfg.OpenTimeout)
+		if err := db.withBreaker(succeed); err != nil {
+			t.Fatalf("Expected trial request to succeed, received: %v", err)
+		}
+		if db.CircuitState() != CircuitClosed {
+			t.Errorf("Expected successful trial to close the circuit, circuit is %s", db.CircuitState())
+		}
+	})
+
+	t.Run("TestWithTxFailsFastWhenOpen", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.ConfigureCircuitBreaker(cfg)
+		defer db.ConfigureCircuitBreaker(cfg)
+		db.withBreaker(fail)
+		db.withBreaker(fail)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := db.WithTx(ctx, func(tx *sql.Tx) error { return nil })
+		if !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Expected ErrCircuitOpen, received: %v", err)
+		}
+	})
+
+	t.Run("TestPartialResults", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.ConfigureCircuitBreaker(cfg)
+		defer db.ConfigureCircuitBreaker(cfg)
+
+		students, err := addStudents([]Student{{Name: "Ada Lovelace", Mobile: "8885551000"}, {Name: "Rob Pike", Mobile: "8885551001"}})
+		if err != nil {
+			t.Fatalf("Expected students to be added, received error: %v", err)
+		}
+
+		db.withBreaker(fail)
+		db.withBreaker(fail)
+
+		all, err := getStudents()
+		var partial *PartialResultError
+		if !errors.As(err, &partial) {
+			t.Fatalf("Expected a *PartialResultError, received: %v", err)
+		}
+		if _, ok := partial.Failed[db.Name]; !ok || len(partial.Failed) != 1 {
+			t.Errorf("Expected only %s to be skipped, received: %v", db.Name, partial.Failed)
+		}
+		if len(all) != 1 || all[0].Name != "Ada Lovelace" {
+			t.Errorf("Expected students from the available partition, received: %+v", all)
+		}
+
+		res, err := getCoursesForStudents(students)
+		if !errors.As(err, &partial) {
+			t.Fatalf("Expected a *PartialResultError, received: %v", err)
+		}
+		if _, ok := res[students[0]]; !ok || len(res) != 1 {
+			t.Errorf("Expected results for the student in the available partition, received: %+v", res)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
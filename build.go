@@ -1,21 +1,47 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
+// sampleTables lists the app's tables in the order they can be dropped.
+var sampleTables = []string{"student_mobiles", "course_seats", "waitlist", "enrollment", "students", "course_offerings", "terms",
+	"course_prerequisites", "courses"}
+
+// createDatabases empties every partition before the sample data is built. sqlite
+// partitions are recreated as empty files at the path in their DSN, which need not
+// match the partition's name; partitions stored in a database server have their
+// tables dropped instead.
 func createDatabases(dbs []*Database) error {
 	for i := range dbs {
+		// partitions stored in a database server are emptied rather than recreated
+		if dbs[i].Dialect != SQLiteDialect {
+			log.Printf("Dropping existing tables in %s...", dbs[i].Name)
+			for _, table := range sampleTables {
+				if _, err := dbs[i].db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s CASCADE", table)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		path, ok := sqliteFilePath(dbs[i].dsn)
+		if !ok {
+			continue
+		}
+
 		// remove db if it exists already to ensure we
-		// don't duplicate data.
-		if err := os.Remove(dbs[i].Name); err != nil {
-			log.Printf("Attempted to remove existing database: %s, Error: %s", dbs[i].Name, err.Error())
+		// don't duplicate data. A leftover WAL would be replayed into the new file.
+		for _, f := range []string{path, path + "-wal", path + "-shm"} {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				log.Printf("Attempted to remove existing database: %s, Error: %s", f, err.Error())
+			}
 		}
 
-		file, err := os.Create(dbs[i].Name)
+		file, err := os.Create(path)
 		if err != nil {
 			return err
 		}
@@ -26,14 +52,17 @@ func createDatabases(dbs []*Database) error {
 	return nil
 }
 
+// createSchema checks that every sqlite partition's file can be opened before its
+// tables are created.
 func createSchema(dbs []*Database) error {
 	for _, v := range dbs {
-		db, err := sql.Open("sqlite3", fmt.Sprintf("./%s", v.Name))
-		if err != nil {
+		if v.Dialect != SQLiteDialect {
+			continue
+		}
+		if err := v.db.Ping(); err != nil {
 			log.Println(err.Error())
 			return err
 		}
-		defer db.Close()
 
 		log.Printf("Creating schema for %s...", v.Name)
 	}
@@ -44,7 +73,31 @@ func createSchema(dbs []*Database) error {
 func createTables(dbs []*Database) error {
 	courses := `CREATE TABLE IF NOT EXISTS courses (
 		code TEXT PRIMARY KEY,
-		name TEXT NOT NULL
+		name TEXT NOT NULL,
+		capacity INTEGER -- NULL means unlimited
+	) WITHOUT ROWID;`
+
+	prerequisites := `CREATE TABLE IF NOT EXISTS course_prerequisites (
+		course_code TEXT NOT NULL,
+		prerequisite_code TEXT NOT NULL,
+		PRIMARY KEY (course_code, prerequisite_code),
+		FOREIGN KEY (course_code) REFERENCES courses (code),
+		FOREIGN KEY (prerequisite_code) REFERENCES courses (code)
+	) WITHOUT ROWID;`
+
+	terms := `CREATE TABLE IF NOT EXISTS terms (
+		code TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		start_date INTEGER NOT NULL, -- Epoch time
+		end_date INTEGER NOT NULL -- Epoch time
+	) WITHOUT ROWID;`
+
+	offerings := `CREATE TABLE IF NOT EXISTS course_offerings (
+		term_code TEXT NOT NULL,
+		course_code TEXT NOT NULL,
+		PRIMARY KEY (term_code, course_code),
+		FOREIGN KEY (term_code) REFERENCES terms (code),
+		FOREIGN KEY (course_code) REFERENCES courses (code)
 	) WITHOUT ROWID;`
 
 	students := `CREATE TABLE IF NOT EXISTS students (
@@ -53,22 +106,61 @@ func createTables(dbs []*Database) error {
 		mobile TEXT
 	);`
 
+	// LOWER(name) serves the case-insensitive name searches; see searchStudents
+	studentNameIdx := `CREATE INDEX students_name_lower ON students(LOWER(name));`
+
+	studentMobileIdx := `CREATE INDEX students_mobile ON students(mobile);`
+
 	enrollment := `CREATE TABLE IF NOT EXISTS enrollment (
 		student_id INTEGER NOT NULL,
 		course_code TEXT NOT NULL,
+		term_code TEXT NOT NULL,
 		date_enrolled INTEGER NOT NULL, -- Epoch time
 		final_grade TEXT,
-		PRIMARY KEY (student_id, course_code),
+		PRIMARY KEY (student_id, course_code, term_code),
 		FOREIGN KEY (student_id) REFERENCES students (id),
-		FOREIGN KEY (course_code) REFERENCES sources (code)
+		FOREIGN KEY (course_code) REFERENCES courses (code),
+		FOREIGN KEY (term_code) REFERENCES terms (code)
 	) WITHOUT ROWID;`
 
+	enrollmentTermIdx := `CREATE INDEX enrollment_term_code ON enrollment(term_code, student_id);`
+
 	enrollmentIdx := `CREATE INDEX enrollment_date_enrolled ON enrollment(date_enrolled);`
 
-	queries := []string{courses, students, enrollment, enrollmentIdx}
+	waitlist := `CREATE TABLE IF NOT EXISTS waitlist (
+		student_id INTEGER NOT NULL,
+		course_code TEXT NOT NULL,
+		term_code TEXT NOT NULL,
+		date_added INTEGER NOT NULL, -- Epoch time
+		PRIMARY KEY (student_id, course_code, term_code),
+		FOREIGN KEY (student_id) REFERENCES students (id),
+		FOREIGN KEY (course_code) REFERENCES courses (code),
+		FOREIGN KEY (term_code) REFERENCES terms (code)
+	) WITHOUT ROWID;`
+
+	waitlistIdx := `CREATE INDEX waitlist_course_date_added ON waitlist(term_code, course_code, date_added);`
+
+	// only used in the coordinator partition; see GetSeatCounterDatabase
+	courseSeats := `CREATE TABLE IF NOT EXISTS course_seats (
+		term_code TEXT NOT NULL,
+		course_code TEXT NOT NULL,
+		enrolled INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (term_code, course_code)
+	) WITHOUT ROWID;`
+
+	// only used in the coordinator partition; see GetIndexDatabase
+	studentMobiles := `CREATE TABLE IF NOT EXISTS student_mobiles (
+		mobile TEXT PRIMARY KEY,
+		partition_name TEXT NOT NULL,
+		student_id INTEGER NOT NULL -- 0 until the student has been written
+	) WITHOUT ROWID;`
+
+	queries := []string{courses, prerequisites, terms, offerings, students, studentNameIdx, studentMobileIdx,
+		enrollment, enrollmentIdx, enrollmentTermIdx, waitlist, waitlistIdx, courseSeats, studentMobiles}
 
 	for _, partition := range dbs {
 		for _, query := range queries {
+			query = partition.Dialect.DDL(query)
 			log.Printf("Creating db object: %s", query)
 			stmnt, err := partition.db.Prepare(query)
 			if err != nil {
@@ -88,80 +180,115 @@ func createTables(dbs []*Database) error {
 
 func addSampleCourses() {
 	log.Println("Adding sample courses...")
-	c1 := Course{"DB101", "Databases 101"}
-	c2 := Course{"ALGO201", "Algorithms 201"}
-	c3 := Course{"ML301", "Machine Learning 301"}
+	c1 := Course{"DB101", "Databases 101", 30}
+	c2 := Course{"ALGO201", "Algorithms 201", 30}
+	c3 := Course{"ML301", "Machine Learning 301", 2}
 
-	err := addCourse(c1)
+	_, err := addCourse(c1)
 	handleError(err)
-	err = addCourse(c2)
+	_, err = addCourse(c2)
 	handleError(err)
-	err = addCourse(c3)
+	_, err = addCourse(c3)
 	handleError(err)
+
+	// students must pass ALGO201 before taking ML301
+	handleError(addCoursePrerequisite(c3.CourseCode, c2.CourseCode))
 }
 
-func addSampleStudents() {
+// sampleTerm is the term the sample courses are offered and taken in.
+var sampleTerm = Term{
+	Code:      "2021FA",
+	Name:      "Fall 2021",
+	StartDate: time.Date(2021, time.August, 23, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2021, time.December, 17, 0, 0, 0, 0, time.UTC),
+}
+
+// samplePriorTerm is the term before sampleTerm, in which the students taking ML301
+// passed its prerequisite.
+var samplePriorTerm = Term{
+	Code:      "2021SP",
+	Name:      "Spring 2021",
+	StartDate: time.Date(2021, time.January, 11, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2021, time.May, 7, 0, 0, 0, 0, time.UTC),
+}
+
+// addSampleTerms adds the sample terms, offers ALGO201 in the prior term and every
+// sample course in sampleTerm.
+func addSampleTerms() {
+	log.Println("Adding sample terms...")
+	_, err := addTerm(samplePriorTerm)
+	handleError(err)
+	_, err = addTerm(sampleTerm)
+	handleError(err)
+
+	_, err = addCourseOffering(samplePriorTerm, Course{CourseCode: "ALGO201"})
+	handleError(err)
+	for _, code := range []string{"DB101", "ALGO201", "ML301"} {
+		_, err := addCourseOffering(sampleTerm, Course{CourseCode: code})
+		handleError(err)
+	}
+}
+
+// addSampleStudents adds the sample students in one batch and returns them
+// with their generated identifiers.
+func addSampleStudents() []Student {
 	log.Println("Adding sample students...")
 	s1 := Student{
 		Name:   "Rob Pike",
-		Mobile: "8885551111",
+		Mobile: "+18885551111",
 	}
 	s2 := Student{
 		Name:   "Ken Thompson",
-		Mobile: "8885551112",
+		Mobile: "+18885551112",
 	}
 	s3 := Student{
 		Name:   "Robert Griesemer",
-		Mobile: "8885551113",
+		Mobile: "+18885551113",
 	}
 	s4 := Student{
 		Name:   "Russ Cox",
-		Mobile: "8885551114",
+		Mobile: "+18885551114",
 	}
 	s5 := Student{
 		Name:   "Ian Taylor",
-		Mobile: "8885551115",
+		Mobile: "+18885551115",
 	}
 	s6 := Student{
 		Name:   "Guido van Rossum",
-		Mobile: "8885551116",
+		Mobile: "+18885551116",
 	}
-	err := addStudent(s1)
-	handleError(err)
-	err = addStudent(s2)
-	handleError(err)
-	err = addStudent(s3)
-	handleError(err)
-	err = addStudent(s4)
-	handleError(err)
-	err = addStudent(s5)
-	handleError(err)
-	err = addStudent(s6)
+	students, err := addStudents([]Student{s1, s2, s3, s4, s5, s6})
 	handleError(err)
+
+	return students
 }
 
-func addSampleEnrollments() {
+// addSampleEnrollments enrolls the provided students, which must already carry
+// their generated identifiers, into the sample courses for the sample term. Students
+// taking ML301 first pass ALGO201 in the prior term.
+func addSampleEnrollments(s []Student) {
 	log.Println("Adding sample enrollments...")
-	s, err := getStudents()
-	handleError(err)
-
 	if len(s) == 0 {
 		log.Println("Error: no students returned!")
 	}
-	c1 := Course{"DB101", "Databases 101"}
-	c2 := Course{"ALGO201", "Algorithms 201"}
-	c3 := Course{"ML301", "Machine Learning 301"}
+	c1 := Course{"DB101", "Databases 101", 30}
+	c2 := Course{"ALGO201", "Algorithms 201", 30}
+	c3 := Course{"ML301", "Machine Learning 301", 2}
 	cs1 := []Course{c1, c2}
-	cs2 := []Course{c2, c3}
+	cs2 := []Course{c1, c3}
 
 	for i, v := range s {
 		if i%2 == 0 {
-			err := enrollStudent(v, cs1)
+			_, err := enrollStudent(v, sampleTerm, cs1)
 			if err != nil {
 				log.Printf("%v\n", err)
 			}
 		} else {
-			err := enrollStudent(v, cs2)
+			_, err := enrollStudent(v, samplePriorTerm, []Course{c2})
+			handleError(err)
+			handleError(setFinalGrade(v, samplePriorTerm, c2.CourseCode, "A"))
+
+			_, err = enrollStudent(v, sampleTerm, cs2)
 			if err != nil {
 				log.Printf("%v\n", err)
 			}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestBuildDatabases
+// Run sub test:  	go test -run TestBuildDatabases/TestFileFromDSN
+func TestBuildDatabases(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	dir, err := ioutil.TempDir("", "enrollment")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// TESTS //
+	t.Run("TestFileFromDSN", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		path := filepath.Join(dir, "enrollment1.db")
+		db, err := NewDatabase("part1", DSNWithTxLock(path, TxLockImmediate), 'A', 'Z')
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		if err := createDatabases([]*Database{db}); err != nil {
+			t.Fatal(err)
+		}
+		if err := createSchema([]*Database{db}); err != nil {
+			t.Fatal(err)
+		}
+		if err := createTables([]*Database{db}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected the database to be built at %s, received error: %v", path, err)
+		}
+		if _, err := os.Stat("part1"); !os.IsNotExist(err) {
+			os.Remove("part1")
+			t.Error("Expected no file named after the partition")
+		}
+		var n int
+		if err := db.db.QueryRow(`SELECT COUNT(*) FROM students`).Scan(&n); err != nil {
+			t.Errorf("Expected the tables to be created in the partition's database, received error: %v", err)
+		}
+	})
+
+	t.Run("TestSqliteFilePath", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := map[string]string{
+			"./enrollment1.db":                      "./enrollment1.db",
+			"data/enrollment1.db?_txlock=immediate": "data/enrollment1.db",
+			"file:data/enrollment1.db?cache=shared": "data/enrollment1.db",
+			"file:h1?mode=memory":                   "",
+			":memory:":                              "",
+		}
+		for dsn, want := range tests {
+			path, ok := sqliteFilePath(dsn)
+			if path != want || ok != (want != "") {
+				t.Errorf("Expected %q for %q, received: %q, %v", want, dsn, path, ok)
+			}
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
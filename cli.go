@@ -0,0 +1,763 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes of the subcommands.
+const (
+	exitOK = 0
+	// exitError means the command failed.
+	exitError = 1
+	// exitUsage means the command line was invalid.
+	exitUsage = 2
+	// exitPartial means the command ran, but some partitions were unavailable and
+	// their rows are missing from the output.
+	exitPartial = 3
+	// exitNotFound means the command named something that does not exist.
+	exitNotFound = 4
+	// exitConflict means the command would have stored a duplicate or violated a
+	// constraint.
+	exitConflict = 5
+	// exitUnavailable means a partition the command needed was unavailable.
+	exitUnavailable = 6
+)
+
+const cliUsage = `Usage: enrollment [flags] <command> [command flags] [args]
+
+Commands:
+  student add <name> <mobile>
+  student get [-id ID] <student>
+  student list
+  student search [-mode prefix|substring|fuzzy] [-limit N] <name>
+  student search -mobile MOBILE
+  student reindex
+  student update [-id ID] [-name NAME] [-mobile MOBILE] <student>
+  student delete [-id ID] <student>
+  course add [-capacity N] <code> <name>
+  course list
+  term add -start DATE -end DATE <code> <name>
+  term list
+  offering add -term CODE <course code>...
+  offering list -term CODE
+  enroll -term CODE [-id ID] <student> <course code>...
+  withdraw -term CODE [-id ID] <student> <course code>
+  roster [-term CODE] [-bucket DURATION] <course code>
+  transcript [-id ID] <student>
+  enrollments -from DATE -to DATE [-course CODE] [-limit N]
+  report courses [-term CODE] [-limit N]
+  report terms
+  shell
+
+Students are looked up by name, or by ref: the student's partition and id, as in
+enrollment1.db/3. If more than one student has the name, pass the student's -id or
+use their ref. Command flags must come before the arguments. The shell reads SQL
+statements from stdin and runs them against one partition or all of them; type \help
+in the shell for its commands.
+
+Exit codes: 0 success, 1 the command failed, 2 invalid command line, 3 some
+partitions were unavailable and their rows are missing from the output, 4 not
+found, 5 duplicate or constraint violated, 6 a partition was unavailable.
+`
+
+// cli runs the administration subcommands and writes their output as a table or as JSON.
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	format string
+}
+
+// table is the tabular form of a command's output.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// usageError is returned by a subcommand whose command line is invalid.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// runCommand runs the subcommand in args, writes its output to stdout in the given
+// format ("table" or "json") and errors to stderr, and returns the exit code. Only the
+// shell reads stdin.
+func runCommand(args []string, format string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	c := &cli{stdin: stdin, stdout: stdout, stderr: stderr, format: format}
+	if format != "table" && format != "json" {
+		return c.exitCode(usageErrorf("unknown output format %q", format))
+	}
+	if len(args) == 0 {
+		return c.exitCode(usageErrorf("no command given"))
+	}
+
+	var err error
+	switch args[0] {
+	case "student":
+		err = c.student(args[1:])
+	case "course":
+		err = c.course(args[1:])
+	case "term":
+		err = c.term(args[1:])
+	case "offering":
+		err = c.offering(args[1:])
+	case "enroll":
+		err = c.enroll(args[1:])
+	case "withdraw":
+		err = c.withdraw(args[1:])
+	case "roster":
+		err = c.roster(args[1:])
+	case "transcript":
+		err = c.transcript(args[1:])
+	case "enrollments":
+		err = c.enrollments(args[1:])
+	case "report":
+		err = c.report(args[1:])
+	case "shell":
+		err = c.shell(args[1:])
+	case "help":
+		fmt.Fprint(stdout, cliUsage)
+	default:
+		err = usageErrorf("unknown command %q", args[0])
+	}
+	return c.exitCode(err)
+}
+
+// exitCode reports err on stderr and returns the exit code for it.
+func (c *cli) exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var usage *usageError
+	if errors.As(err, &usage) {
+		fmt.Fprintf(c.stderr, "Error: %v\n\n%s", err, cliUsage)
+		return exitUsage
+	}
+	var partial *PartialResultError
+	if errors.As(err, &partial) {
+		fmt.Fprintf(c.stderr, "Warning: %v\n", err)
+		return exitPartial
+	}
+	fmt.Fprintf(c.stderr, "Error: %v\n", err)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrNoPartitionForKey):
+		return exitNotFound
+	case errors.Is(err, ErrDuplicate), errors.Is(err, ErrConstraint):
+		return exitConflict
+	case errors.Is(err, ErrPartitionUnavailable):
+		return exitUnavailable
+	}
+	return exitError
+}
+
+func (c *cli) student(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("student: no subcommand given")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := newFlagSet("student add")
+		if err := parseArgs(fs, args[1:], 2, 2); err != nil {
+			return err
+		}
+		s, err := addStudent(Student{Name: fs.Arg(0), Mobile: fs.Arg(1)})
+		if err != nil {
+			return err
+		}
+		return c.result(s, studentTable(s), nil)
+
+	case "get":
+		fs := newFlagSet("student get")
+		id := fs.Uint64("id", 0, "student id")
+		if err := parseArgs(fs, args[1:], 1, 1); err != nil {
+			return err
+		}
+		if _, ok := parseStudentRef(fs.Arg(0)); ok || *id != 0 {
+			s, err := resolveStudent(fs.Arg(0), *id)
+			if err != nil {
+				return err
+			}
+			return c.result(s, studentTable(s), nil)
+		}
+		matches, err := getStudentsByName(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return kindErrorf(ErrNotFound, "Unable to find student %s", fs.Arg(0))
+		}
+		return c.result(matches, studentMatchTable(matches, false), nil)
+
+	case "list":
+		fs := newFlagSet("student list")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		students, err := getStudents()
+		return c.result(students, studentTable(students...), err)
+
+	case "search":
+		fs := newFlagSet("student search")
+		mode := fs.String("mode", string(SearchPrefix), "prefix, substring or fuzzy")
+		mobile := fs.String("mobile", "", "find the students with this mobile number")
+		limit := fs.Int("limit", 0, "maximum number of students, 0 for all")
+		if err := parseArgs(fs, args[1:], 0, 1); err != nil {
+			return err
+		}
+		if (*mobile == "") == (fs.NArg() == 0) {
+			return usageErrorf("student search: pass either a name or -mobile")
+		}
+		var matches []StudentMatch
+		var err error
+		if *mobile != "" {
+			matches, err = searchStudentsByMobile(*mobile)
+		} else {
+			switch SearchMode(*mode) {
+			case SearchPrefix, SearchSubstring, SearchFuzzy:
+			default:
+				return usageErrorf("student search: unknown mode %q", *mode)
+			}
+			matches, err = searchStudents(fs.Arg(0), SearchMode(*mode), *limit)
+		}
+		return c.result(matches, studentMatchTable(matches, SearchMode(*mode) == SearchFuzzy && *mobile == ""), err)
+
+	case "reindex":
+		fs := newFlagSet("student reindex")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		n, err := rebuildMobileIndex()
+		if err != nil {
+			return err
+		}
+		return c.message("Indexed %d mobile numbers", n)
+
+	case "update":
+		fs := newFlagSet("student update")
+		id := fs.Uint64("id", 0, "student id")
+		name := fs.String("name", "", "new name")
+		mobile := fs.String("mobile", "", "new mobile number")
+		if err := parseArgs(fs, args[1:], 1, 1); err != nil {
+			return err
+		}
+		if *name == "" && *mobile == "" {
+			return usageErrorf("student update: nothing to update, pass -name or -mobile")
+		}
+		s, err := resolveStudent(fs.Arg(0), *id)
+		if err != nil {
+			return err
+		}
+		updated := s
+		if *name != "" {
+			updated.Name = *name
+		}
+		if *mobile != "" {
+			updated.Mobile = *mobile
+		}
+		updated, err = updateStudent(s, updated)
+		if err != nil {
+			return err
+		}
+		return c.result(updated, studentTable(updated), nil)
+
+	case "delete":
+		fs := newFlagSet("student delete")
+		id := fs.Uint64("id", 0, "student id")
+		if err := parseArgs(fs, args[1:], 1, 1); err != nil {
+			return err
+		}
+		s, err := resolveStudent(fs.Arg(0), *id)
+		if err != nil {
+			return err
+		}
+		if err := deleteStudent(s); err != nil {
+			return err
+		}
+		return c.result(s, studentTable(s), nil)
+	}
+	return usageErrorf("student: unknown subcommand %q", args[0])
+}
+
+func (c *cli) course(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("course: no subcommand given")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := newFlagSet("course add")
+		capacity := fs.Int("capacity", 0, "maximum number of students; 0 means unlimited")
+		if err := parseArgs(fs, args[1:], 2, 2); err != nil {
+			return err
+		}
+		course, err := addCourse(Course{CourseCode: fs.Arg(0), Name: fs.Arg(1), Capacity: *capacity})
+		if err != nil {
+			return err
+		}
+		return c.result(course, courseTable(course), nil)
+
+	case "list":
+		fs := newFlagSet("course list")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		courses, err := getAllCourses()
+		if err != nil {
+			return err
+		}
+		return c.result(courses, courseTable(courses...), nil)
+	}
+	return usageErrorf("course: unknown subcommand %q", args[0])
+}
+
+func (c *cli) term(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("term: no subcommand given")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := newFlagSet("term add")
+		startFlag := fs.String("start", "", "first day of the term: YYYY-MM-DD or RFC 3339")
+		endFlag := fs.String("end", "", "last day of the term: YYYY-MM-DD or RFC 3339")
+		if err := parseArgs(fs, args[1:], 2, 2); err != nil {
+			return err
+		}
+		start, err := parseTime(*startFlag)
+		if err != nil {
+			return usageErrorf("term add: -start: %v", err)
+		}
+		end, err := parseTime(*endFlag)
+		if err != nil {
+			return usageErrorf("term add: -end: %v", err)
+		}
+		term, err := addTerm(Term{Code: fs.Arg(0), Name: fs.Arg(1), StartDate: start, EndDate: end})
+		if err != nil {
+			return err
+		}
+		return c.result(term, termTable(term), nil)
+
+	case "list":
+		fs := newFlagSet("term list")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		terms, err := getTerms()
+		if err != nil {
+			return err
+		}
+		return c.result(terms, termTable(terms...), nil)
+	}
+	return usageErrorf("term: unknown subcommand %q", args[0])
+}
+
+func (c *cli) offering(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("offering: no subcommand given")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := newFlagSet("offering add")
+		term := fs.String("term", "", "term code")
+		if err := parseArgs(fs, args[1:], 1, -1); err != nil {
+			return err
+		}
+		if *term == "" {
+			return usageErrorf("offering add: -term is required")
+		}
+		var offerings []CourseOffering
+		for _, code := range fs.Args() {
+			o, err := addCourseOffering(Term{Code: *term}, Course{CourseCode: code})
+			if err != nil {
+				return err
+			}
+			offerings = append(offerings, o)
+		}
+		t := table{header: []string{"TERM", "COURSE"}}
+		for _, o := range offerings {
+			t.rows = append(t.rows, []string{o.TermCode, o.CourseCode})
+		}
+		return c.result(offerings, t, nil)
+
+	case "list":
+		fs := newFlagSet("offering list")
+		term := fs.String("term", "", "term code")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		if *term == "" {
+			return usageErrorf("offering list: -term is required")
+		}
+		courses, err := getCourseOfferings(Term{Code: *term})
+		if err != nil {
+			return err
+		}
+		return c.result(courses, courseTable(courses...), nil)
+	}
+	return usageErrorf("offering: unknown subcommand %q", args[0])
+}
+
+func (c *cli) enroll(args []string) error {
+	fs := newFlagSet("enroll")
+	term := fs.String("term", "", "term code")
+	id := fs.Uint64("id", 0, "student id")
+	if err := parseArgs(fs, args, 2, -1); err != nil {
+		return err
+	}
+	if *term == "" {
+		return usageErrorf("enroll: -term is required")
+	}
+
+	s, err := resolveStudent(fs.Arg(0), *id)
+	if err != nil {
+		return err
+	}
+	var courses []Course
+	for _, code := range fs.Args()[1:] {
+		courses = append(courses, Course{CourseCode: code})
+	}
+
+	enrollments, err := enrollStudent(s, Term{Code: *term}, courses)
+	if err != nil {
+		return err
+	}
+	return c.result(enrollments, enrollmentTable(enrollments), nil)
+}
+
+func (c *cli) withdraw(args []string) error {
+	fs := newFlagSet("withdraw")
+	term := fs.String("term", "", "term code")
+	id := fs.Uint64("id", 0, "student id")
+	if err := parseArgs(fs, args, 2, 2); err != nil {
+		return err
+	}
+	if *term == "" {
+		return usageErrorf("withdraw: -term is required")
+	}
+
+	s, err := resolveStudent(fs.Arg(0), *id)
+	if err != nil {
+		return err
+	}
+	if err := withdrawStudent(s, Term{Code: *term}, Course{CourseCode: fs.Arg(1)}); err != nil {
+		return err
+	}
+	return c.message("Withdrew %s (id %d) from %s in %s", s.Name, s.ID, fs.Arg(1), *term)
+}
+
+func (c *cli) roster(args []string) error {
+	fs := newFlagSet("roster")
+	term := fs.String("term", "", "term code; all terms if empty")
+	bucket := fs.Duration("bucket", 24*time.Hour, "period to count enrollments over time by")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	roster, err := getCourseRoster(fs.Arg(0), *term, *bucket)
+	entries := table{header: []string{"ID", "NAME", "MOBILE", "PARTITION", "TERM", "ENROLLED", "GRADE"}}
+	for _, e := range roster.Entries {
+		entries.rows = append(entries.rows, []string{
+			strconv.FormatUint(e.Student.ID, 10), e.Student.Name, e.Student.Mobile, e.Partition, e.TermCode, e.DateEnrolled.Format("2006-01-02"), e.FinalGrade,
+		})
+	}
+	summary := table{header: []string{"HEADCOUNT", "UNGRADED"}, rows: [][]string{{strconv.Itoa(roster.Stats.Headcount), strconv.Itoa(roster.Stats.Ungraded)}}}
+	grades := table{header: []string{"GRADE", "COUNT"}}
+	for _, g := range sortedKeys(roster.Stats.Grades) {
+		grades.rows = append(grades.rows, []string{g, strconv.Itoa(roster.Stats.Grades[g])})
+	}
+	overTime := table{header: []string{"PERIOD START", "ENROLLMENTS"}}
+	for _, b := range roster.Stats.EnrollmentsOverTime {
+		overTime.rows = append(overTime.rows, []string{b.Start.Format(time.RFC3339), strconv.Itoa(b.Count)})
+	}
+	return c.result(roster, entries, err, summary, grades, overTime)
+}
+
+func (c *cli) transcript(args []string) error {
+	fs := newFlagSet("transcript")
+	id := fs.Uint64("id", 0, "student id")
+	if err := parseArgs(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	s, err := resolveStudent(fs.Arg(0), *id)
+	if err != nil {
+		return err
+	}
+	enrollments, err := getTranscript(s)
+	if err != nil {
+		return err
+	}
+	return c.result(enrollments, enrollmentTable(enrollments), nil)
+}
+
+func (c *cli) enrollments(args []string) error {
+	fs := newFlagSet("enrollments")
+	fromFlag := fs.String("from", "", "start of the window, inclusive: YYYY-MM-DD or RFC 3339")
+	toFlag := fs.String("to", "", "end of the window, exclusive: YYYY-MM-DD or RFC 3339")
+	course := fs.String("course", "", "course code; all courses if empty")
+	limit := fs.Int("limit", 0, "maximum number of enrollments; 0 means every enrollment")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+	from, err := parseTime(*fromFlag)
+	if err != nil {
+		return usageErrorf("enrollments: -from: %v", err)
+	}
+	to, err := parseTime(*toFlag)
+	if err != nil {
+		return usageErrorf("enrollments: -to: %v", err)
+	}
+
+	records, err := getEnrollmentsBetween(from, to, *course, *limit)
+	t := table{header: []string{"ENROLLED", "PARTITION", "ID", "NAME", "TERM", "COURSE", "GRADE"}}
+	for _, r := range records {
+		t.rows = append(t.rows, []string{
+			r.Enrollment.DateEnrolled.Format(time.RFC3339), r.Partition, strconv.FormatUint(r.Student.ID, 10), r.Student.Name,
+			r.Enrollment.TermCode, r.Enrollment.CourseCode, r.Enrollment.FinalGrade,
+		})
+	}
+	return c.result(records, t, err)
+}
+
+// parseTime parses a date, as midnight UTC, or an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("a date is required")
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (c *cli) report(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("report: no report given")
+	}
+
+	switch args[0] {
+	case "courses":
+		fs := newFlagSet("report courses")
+		term := fs.String("term", "", "term code; all terms if empty")
+		limit := fs.Int("limit", 0, "maximum number of courses; 0 means every course")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		counts, err := getEnrollmentCountsByCourse(*term, *limit)
+		t := table{header: []string{"COURSE", "ENROLLED"}}
+		for _, c := range counts {
+			t.rows = append(t.rows, []string{c.CourseCode, strconv.Itoa(c.Enrolled)})
+		}
+		return c.result(counts, t, err)
+
+	case "terms":
+		fs := newFlagSet("report terms")
+		if err := parseArgs(fs, args[1:], 0, 0); err != nil {
+			return err
+		}
+		summaries, err := getTermEnrollmentSummaries()
+		t := table{header: []string{"TERM", "ENROLLMENTS", "AVG GRADE POINTS", "FIRST ENROLLED", "LAST ENROLLED"}}
+		for _, s := range summaries {
+			t.rows = append(t.rows, []string{
+				s.TermCode, strconv.Itoa(s.Enrollments), strconv.FormatFloat(s.AverageGradePoints, 'f', 2, 64),
+				s.FirstEnrolled.Format("2006-01-02"), s.LastEnrolled.Format("2006-01-02"),
+			})
+		}
+		return c.result(summaries, t, err)
+	}
+	return usageErrorf("report: unknown report %q", args[0])
+}
+
+// resolveStudent finds the student identified by arg, which is either a ref
+// (partition/id) or a name. Given a name and an id that is not zero, it finds the
+// student with both. It fails if no student matches, or if id is zero and more than
+// one student has the name.
+func resolveStudent(arg string, id uint64) (Student, error) {
+	if ref, ok := parseStudentRef(arg); ok {
+		if id != 0 {
+			return Student{}, usageErrorf("pass either a ref or -id, not both")
+		}
+		return getStudent(ref)
+	}
+
+	if id == 0 {
+		m, err := findStudentByName(arg)
+		var ambiguous *AmbiguousStudentError
+		if errors.As(err, &ambiguous) {
+			return Student{}, fmt.Errorf("%w; pass -id or the student's ref to choose one", err)
+		}
+		return m.Student, err
+	}
+
+	matches, err := getStudentsByName(arg)
+	if err != nil {
+		return Student{}, err
+	}
+	for _, m := range matches {
+		if m.Student.ID == id {
+			return m.Student, nil
+		}
+	}
+	return Student{}, kindErrorf(ErrNotFound, "Unable to find student %s with id %d", arg, id)
+}
+
+// result writes v, or the tables in table format, unless err is an error other than a
+// *PartialResultError, and returns err.
+func (c *cli) result(v interface{}, t table, err error, more ...table) error {
+	var partial *PartialResultError
+	if err != nil && !errors.As(err, &partial) {
+		return err
+	}
+
+	if c.format == "json" {
+		// print an empty list as [] rather than null
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+			v = []struct{}{}
+		}
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		if encErr := enc.Encode(v); encErr != nil {
+			return encErr
+		}
+		return err
+	}
+
+	for i, t := range append([]table{t}, more...) {
+		if i > 0 {
+			fmt.Fprintln(c.stdout)
+		}
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		if flushErr := w.Flush(); flushErr != nil {
+			return flushErr
+		}
+	}
+	return err
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// message writes the outcome of a command that returns no rows.
+func (c *cli) message(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if c.format == "json" {
+		return json.NewEncoder(c.stdout).Encode(map[string]string{"message": msg})
+	}
+	_, err := fmt.Fprintln(c.stdout, msg)
+	return err
+}
+
+func studentTable(students ...Student) table {
+	t := table{header: []string{"REF", "ID", "NAME", "MOBILE"}}
+	for _, s := range students {
+		t.rows = append(t.rows, []string{studentRefOf(s).String(), strconv.FormatUint(s.ID, 10), s.Name, s.Mobile})
+	}
+	return t
+}
+
+// studentMatchTable lists the matches of a search, with the distance of each match
+// if distance is set.
+func studentMatchTable(matches []StudentMatch, distance bool) table {
+	t := table{header: []string{"REF", "ID", "NAME", "MOBILE"}}
+	if distance {
+		t.header = append(t.header, "DISTANCE")
+	}
+	for _, m := range matches {
+		row := []string{m.Ref().String(), strconv.FormatUint(m.Student.ID, 10), m.Student.Name, m.Student.Mobile}
+		if distance {
+			row = append(row, strconv.Itoa(m.Distance))
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t
+}
+
+func courseTable(courses ...Course) table {
+	t := table{header: []string{"CODE", "NAME", "CAPACITY"}}
+	for _, c := range courses {
+		capacity := "unlimited"
+		if c.Capacity > 0 {
+			capacity = strconv.Itoa(c.Capacity)
+		}
+		t.rows = append(t.rows, []string{c.CourseCode, c.Name, capacity})
+	}
+	return t
+}
+
+func termTable(terms ...Term) table {
+	t := table{header: []string{"CODE", "NAME", "START", "END"}}
+	for _, term := range terms {
+		t.rows = append(t.rows, []string{term.Code, term.Name, term.StartDate.Format("2006-01-02"), term.EndDate.Format("2006-01-02")})
+	}
+	return t
+}
+
+func enrollmentTable(enrollments []Enrollment) table {
+	t := table{header: []string{"STUDENT ID", "TERM", "COURSE", "STATUS", "DATE", "GRADE"}}
+	for _, e := range enrollments {
+		t.rows = append(t.rows, []string{
+			strconv.FormatUint(e.StudentID, 10), e.TermCode, e.CourseCode, string(e.Status), e.DateEnrolled.Format("2006-01-02"), e.FinalGrade,
+		})
+	}
+	return t
+}
+
+// newFlagSet returns a flag set for a subcommand. Parse errors are reported by
+// runCommand, so the flag set itself prints nothing.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// parseArgs parses a subcommand's flags and checks that it was given between min and
+// max arguments; a negative max means no upper limit.
+func parseArgs(fs *flag.FlagSet, args []string, min int, max int) error {
+	if err := fs.Parse(args); err != nil {
+		return usageErrorf("%s: %v", fs.Name(), err)
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return usageErrorf("%s: wrong number of arguments", fs.Name())
+	}
+	return nil
+}
+
+// fatal reports an error that stops the app from starting and exits.
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(exitError)
+}
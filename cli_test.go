@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestCLI
+// Run sub test:  	go test -run TestCLI/TestStudentAddAndList
+func TestCLI(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	run := func(format string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCommand(args, format, nil, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	// TESTS //
+	t.Run("TestStudentAddAndList", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		for _, s := range [][]string{{"Ken Thompson", "8885551112"}, {"Rob Pike", "8885551111"}} {
+			if code, _, stderr := run("table", "student", "add", s[0], s[1]); code != exitOK {
+				t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+			}
+		}
+
+		code, stdout, stderr := run("json", "student", "list")
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+		var students []Student
+		if err := json.Unmarshal([]byte(stdout), &students); err != nil {
+			t.Fatalf("Expected JSON output, received %q, error: %v", stdout, err)
+		}
+		if len(students) != 2 || students[0].Name != "Ken Thompson" || students[1].Name != "Rob Pike" {
+			t.Errorf("Expected both students, received: %+v", students)
+		}
+
+		_, stdout, _ = run("table", "student", "get", "Rob Pike")
+		if !strings.HasPrefix(stdout, "REF") || !strings.Contains(stdout, "enrollment2.db/1") || !strings.Contains(stdout, "8885551111") {
+			t.Errorf("Expected a table with Rob Pike, received: %q", stdout)
+		}
+	})
+
+	t.Run("TestAmbiguousNameRequiresID", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if code, _, _ := run("table", "student", "add", "Ken Thompson", "8885550000"); code != exitOK {
+			t.Fatalf("Expected second Ken Thompson to be added, exit code %d", code)
+		}
+
+		code, _, stderr := run("table", "student", "update", "-mobile", "8885551234", "Ken Thompson")
+		if code != exitError || !strings.Contains(stderr, "pass -id") {
+			t.Fatalf("Expected ambiguous name to be rejected, received exit code %d: %s", code, stderr)
+		}
+
+		code, stdout, stderr := run("json", "student", "update", "-id", "2", "-mobile", "8885551234", "Ken Thompson")
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+		var s Student
+		if err := json.Unmarshal([]byte(stdout), &s); err != nil || s.ID != 2 || s.Mobile != "+18885551234" {
+			t.Errorf("Expected updated student 2, received %q, error: %v", stdout, err)
+		}
+
+		code, stdout, stderr = run("table", "student", "get", "enrollment1.db/2")
+		if code != exitOK || !strings.Contains(stdout, "+18885551234") {
+			t.Errorf("Expected student 2 by ref, received exit code %d: %s%s", code, stdout, stderr)
+		}
+	})
+
+	t.Run("TestEnrollTranscriptAndDelete", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		addTestCourse(t, Course{"ML301", "Machine Learning 301", 1})
+
+		if code, _, stderr := run("table", "enroll", "-term", testTerm.Code, "Rob Pike", "ML301"); code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+		code, stdout, stderr := run("table", "enroll", "-term", testTerm.Code, "-id", "1", "Ken Thompson", "ML301")
+		if code != exitOK || !strings.Contains(stdout, "waitlisted") {
+			t.Fatalf("Expected Ken Thompson to be waitlisted, received exit code %d: %s%s", code, stdout, stderr)
+		}
+
+		if code, _, stderr := run("table", "student", "delete", "Rob Pike"); code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+
+		_, stdout, _ = run("json", "transcript", "-id", "1", "Ken Thompson")
+		var transcript []Enrollment
+		if err := json.Unmarshal([]byte(stdout), &transcript); err != nil {
+			t.Fatalf("Expected JSON output, received %q, error: %v", stdout, err)
+		}
+		if len(transcript) != 1 || transcript[0].Status != EnrollmentStatusEnrolled {
+			t.Errorf("Expected the deleted student's seat to go to Ken Thompson, received: %+v", transcript)
+		}
+
+		_, stdout, _ = run("json", "roster", "ML301")
+		var roster CourseRoster
+		if err := json.Unmarshal([]byte(stdout), &roster); err != nil || len(roster.Entries) != 1 || roster.Entries[0].Student.Name != "Ken Thompson" {
+			t.Errorf("Expected only Ken Thompson on the roster, received %q, error: %v", stdout, err)
+		}
+	})
+
+	t.Run("TestTermAndOfferingBootstrap", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		steps := [][]string{
+			{"term", "add", "-start", "2022-01-10", "-end", "2022-05-06", "2022SP", "Spring 2022"},
+			{"course", "add", "NET101", "Networks 101"},
+			{"offering", "add", "-term", "2022SP", "NET101"},
+			{"student", "add", "Ada Lovelace", "8885551199"},
+			{"enroll", "-term", "2022SP", "Ada Lovelace", "NET101"},
+		}
+		for _, args := range steps {
+			if code, _, stderr := run("table", args...); code != exitOK {
+				t.Fatalf("Expected exit code %d for %q, received %d: %s", exitOK, args, code, stderr)
+			}
+		}
+
+		_, stdout, _ := run("json", "term", "list")
+		var terms []Term
+		if err := json.Unmarshal([]byte(stdout), &terms); err != nil || len(terms) != 2 || terms[1].Code != "2022SP" {
+			t.Errorf("Expected the new term to be listed, received %q, error: %v", stdout, err)
+		}
+		_, stdout, _ = run("json", "offering", "list", "-term", "2022SP")
+		var offered []Course
+		if err := json.Unmarshal([]byte(stdout), &offered); err != nil || len(offered) != 1 || offered[0].CourseCode != "NET101" {
+			t.Errorf("Expected NET101 to be offered in 2022SP, received %q, error: %v", stdout, err)
+		}
+
+		tests := []struct {
+			args []string
+			code int
+		}{
+			{[]string{"term", "add", "-start", "2022-05-06", "-end", "2022-01-10", "2022SU", "Summer 2022"}, exitError},
+			{[]string{"term", "add", "-end", "2022-01-10", "2022SU", "Summer 2022"}, exitUsage},
+			{[]string{"term", "add", "-start", "2022-01-10", "-end", "2022-05-06", "2022SP", "Spring 2022"}, exitConflict},
+			{[]string{"offering", "add", "-term", "2022SP", "NOPE101"}, exitNotFound},
+			{[]string{"offering", "add", "-term", "2030FA", "NET101"}, exitNotFound},
+			{[]string{"offering", "add", "NET101"}, exitUsage},
+		}
+		for _, tt := range tests {
+			if code, _, _ := run("table", tt.args...); code != tt.code {
+				t.Errorf("Expected exit code %d for %q, received %d", tt.code, tt.args, code)
+			}
+		}
+	})
+
+	t.Run("TestUsageErrors", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := [][]string{
+			{},
+			{"bogus"},
+			{"student"},
+			{"student", "add", "Ken Thompson"},
+			{"enroll", "Ken Thompson", "ML301"},
+			{"student", "get", "-nope", "Ken Thompson"},
+		}
+		for _, args := range tests {
+			if code, _, _ := run("table", args...); code != exitUsage {
+				t.Errorf("Expected exit code %d for %q, received %d", exitUsage, args, code)
+			}
+		}
+		if code, _, _ := run("yaml", "student", "list"); code != exitUsage {
+			t.Errorf("Expected unknown output format to be rejected, received exit code %d", code)
+		}
+	})
+
+	t.Run("TestErrorExitCodes", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if code, _, _ := run("table", "student", "get", "Nobody Here"); code != exitNotFound {
+			t.Errorf("Expected exit code %d for an unknown student, received %d", exitNotFound, code)
+		}
+		if code, _, stderr := run("table", "course", "add", "OS101", "Operating Systems 101"); code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+		if code, _, _ := run("table", "course", "add", "OS101", "Operating Systems 101"); code != exitConflict {
+			t.Errorf("Expected exit code %d for a duplicate course, received %d", exitConflict, code)
+		}
+
+		// a write routed to a single partition by the shell
+		var stdout, stderr bytes.Buffer
+		script := "\\use enrollment1.db\nINSERT INTO courses(code, name) VALUES ('OS101', 'Operating Systems 101');\n"
+		if code := runCommand([]string{"shell"}, "table", strings.NewReader(script), &stdout, &stderr); code != exitConflict {
+			t.Errorf("Expected exit code %d for a constraint violation on one partition, received %d: %s", exitConflict, code, stderr.String())
+		}
+	})
+
+	t.Run("TestPartialResultExitCode", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		pm, release := topology.Acquire()
+		release()
+		db := pm.DBs[1]
+		cfg := CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1}
+		db.ConfigureCircuitBreaker(cfg)
+		defer db.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		db.withBreaker(func() error { return context.DeadlineExceeded })
+
+		code, stdout, stderr := run("table", "student", "list")
+		if code != exitPartial {
+			t.Errorf("Expected exit code %d, received %d", exitPartial, code)
+		}
+		if !strings.Contains(stdout, "Ken Thompson") || !strings.Contains(stderr, db.Name) {
+			t.Errorf("Expected students from the available partition and a warning, received: %q, %q", stdout, stderr)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
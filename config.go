@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PartitionConfig is the partition layout loaded from the config file at startup.
+type PartitionConfig struct {
+	Partitions []PartitionDefinition `json:"partitions"`
+	// CoordinatorPartition names the partition that holds the cross-partition seat
+	// counters and the global mobile number index. Defaults to the first partition.
+	// It cannot be changed by a reload, since the counters and index are not moved.
+	CoordinatorPartition string `json:"coordinator_partition,omitempty"`
+}
+
+// PartitionDefinition describes one database partition. Every partition is routed
+// either by a range of partition keys (KeyRange) or by a share of the hashed key
+// space (HashWeight); a config must use the same strategy for every partition.
+type PartitionDefinition struct {
+	Name string `json:"name"`
+	// Dialect is the backend the partition is stored in: "sqlite3" (the default) or
+	// "postgres". The tx_lock, journal_mode, busy_timeout, split_read_write and
+	// replicas settings only apply to sqlite.
+	Dialect    string     `json:"dialect,omitempty"`
+	DSN        string     `json:"dsn"`
+	TxLock     TxLock     `json:"tx_lock,omitempty"`
+	KeyRange   *KeyRange  `json:"key_range,omitempty"`
+	HashWeight int        `json:"hash_weight,omitempty"`
+	Pool       PoolConfig `json:"pool"`
+	// JournalMode is the sqlite journal mode, e.g. "wal". Empty keeps the file's
+	// current mode.
+	JournalMode string `json:"journal_mode,omitempty"`
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// connection before failing with SQLITE_BUSY. Zero keeps the driver default.
+	BusyTimeout Duration `json:"busy_timeout,omitempty"`
+	// Replicas are read-only copies of the partition that serve reads.
+	Replicas []ReplicaDefinition `json:"replicas,omitempty"`
+	// ReplicationInterval is how often replicas are re-synced from the partition.
+	// Defaults to defaultReplicationInterval.
+	ReplicationInterval Duration `json:"replication_interval,omitempty"`
+	// MaxReadStaleness bounds how far behind the partition a replica may be and
+	// still serve reads. Zero allows any synced replica.
+	MaxReadStaleness Duration `json:"max_read_staleness,omitempty"`
+	// CircuitBreaker overrides DefaultCircuitBreakerConfig for the partition.
+	CircuitBreaker CircuitBreakerDefinition `json:"circuit_breaker"`
+}
+
+// ReplicaDefinition describes a read replica of a partition.
+type ReplicaDefinition struct {
+	Name string `json:"name"`
+	DSN  string `json:"dsn"`
+}
+
+const defaultReplicationInterval = 5 * time.Second
+
+// KeyRange is an inclusive range of partition keys, e.g. "A" through "M".
+type KeyRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// PoolConfig holds connection pool settings for a partition. Zero values leave
+// the database/sql defaults in place.
+type PoolConfig struct {
+	MaxOpenConns    int      `json:"max_open_conns,omitempty"`
+	MaxIdleConns    int      `json:"max_idle_conns,omitempty"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time,omitempty"`
+	// SplitReadWrite gives the partition a single writer connection and a separate
+	// pool of MaxOpenConns read-only connections. It requires the WAL journal mode.
+	SplitReadWrite bool `json:"split_read_write,omitempty"`
+}
+
+// journalModes are the sqlite journal modes accepted in config.
+var journalModes = map[string]bool{"delete": true, "truncate": true, "persist": true, "memory": true, "wal": true, "off": true}
+
+// CircuitBreakerDefinition holds circuit breaker settings for a partition. Zero
+// values use the DefaultCircuitBreakerConfig setting.
+type CircuitBreakerDefinition struct {
+	FailureThreshold    int      `json:"failure_threshold,omitempty"`
+	OpenTimeout         Duration `json:"open_timeout,omitempty"`
+	HalfOpenMaxRequests int      `json:"half_open_max_requests,omitempty"`
+}
+
+// Duration is a time.Duration that is read from config as a string such as "30m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadPartitionConfig reads and validates the partition config file at path.
+func LoadPartitionConfig(path string) (*PartitionConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg PartitionConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("Unable to parse partition config %s: %v", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid partition config %s: %v", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks that every partition is well formed and that the key ranges are
+// contiguous, do not overlap, and cover the whole partition key space. All problems
+// found are reported in a single error.
+func (c *PartitionConfig) Validate() error {
+	var problems []string
+	if len(c.Partitions) == 0 {
+		problems = append(problems, "no partitions defined")
+	}
+
+	names := make(map[string]bool)
+	var ranged, hashed int
+	for i, p := range c.Partitions {
+		label := fmt.Sprintf("partition %d (%s)", i, p.Name)
+		if strings.TrimSpace(p.Name) == "" {
+			problems = append(problems, fmt.Sprintf("partition %d: name is required", i))
+		} else if names[p.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate name", label))
+		}
+		names[p.Name] = true
+
+		if strings.TrimSpace(p.DSN) == "" {
+			problems = append(problems, fmt.Sprintf("%s: dsn is required", label))
+		}
+
+		switch p.TxLock {
+		case "", TxLockDeferred, TxLockImmediate, TxLockExclusive:
+		default:
+			problems = append(problems, fmt.Sprintf("%s: invalid tx_lock %q", label, p.TxLock))
+		}
+
+		if dialect, err := dialectByName(p.Dialect); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+		} else if dialect != SQLiteDialect {
+			if p.TxLock != "" || p.JournalMode != "" || p.BusyTimeout != 0 || p.Pool.SplitReadWrite || len(p.Replicas) > 0 {
+				problems = append(problems, fmt.Sprintf("%s: tx_lock, journal_mode, busy_timeout, split_read_write and replicas are only supported for sqlite",
+					label))
+			}
+		}
+
+		switch {
+		case p.KeyRange != nil && p.HashWeight != 0:
+			problems = append(problems, fmt.Sprintf("%s: key_range and hash_weight are mutually exclusive", label))
+		case p.KeyRange != nil:
+			ranged++
+			if _, _, err := p.KeyRange.runes(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+			}
+		case p.HashWeight < 0:
+			problems = append(problems, fmt.Sprintf("%s: hash_weight must be positive", label))
+		case p.HashWeight > 0:
+			hashed++
+		default:
+			problems = append(problems, fmt.Sprintf("%s: one of key_range or hash_weight is required", label))
+		}
+
+		for j, r := range p.Replicas {
+			if strings.TrimSpace(r.Name) == "" || strings.TrimSpace(r.DSN) == "" {
+				problems = append(problems, fmt.Sprintf("%s: replica %d requires a name and dsn", label, j))
+			}
+			if r.DSN == p.DSN {
+				problems = append(problems, fmt.Sprintf("%s: replica %s must not use the partition's dsn", label, r.Name))
+			}
+		}
+		if p.ReplicationInterval < 0 || p.MaxReadStaleness < 0 {
+			problems = append(problems, fmt.Sprintf("%s: replication_interval and max_read_staleness must not be negative", label))
+		}
+
+		if p.Pool.MaxOpenConns < 0 || p.Pool.MaxIdleConns < 0 || p.Pool.ConnMaxLifetime < 0 || p.Pool.ConnMaxIdleTime < 0 {
+			problems = append(problems, fmt.Sprintf("%s: pool settings must not be negative", label))
+		}
+
+		if p.JournalMode != "" && !journalModes[strings.ToLower(p.JournalMode)] {
+			problems = append(problems, fmt.Sprintf("%s: invalid journal_mode %q", label, p.JournalMode))
+		}
+		if p.Pool.SplitReadWrite && !strings.EqualFold(p.JournalMode, "wal") {
+			problems = append(problems, fmt.Sprintf("%s: pool split_read_write requires journal_mode wal", label))
+		}
+		if p.BusyTimeout < 0 {
+			problems = append(problems, fmt.Sprintf("%s: busy_timeout must not be negative", label))
+		}
+
+		cb := p.CircuitBreaker
+		if cb.FailureThreshold < 0 || cb.OpenTimeout < 0 || cb.HalfOpenMaxRequests < 0 {
+			problems = append(problems, fmt.Sprintf("%s: circuit_breaker settings must not be negative", label))
+		}
+	}
+
+	if c.CoordinatorPartition != "" && !names[c.CoordinatorPartition] {
+		problems = append(problems, fmt.Sprintf("coordinator_partition %q is not a partition", c.CoordinatorPartition))
+	}
+
+	if ranged > 0 && hashed > 0 {
+		problems = append(problems, "partitions must all use key_range or all use hash_weight")
+	}
+	if ranged > 0 && hashed == 0 && len(problems) == 0 {
+		problems = append(problems, c.validateKeyRanges()...)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateKeyRanges reports gaps and overlaps between the partition key ranges.
+func (c *PartitionConfig) validateKeyRanges() []string {
+	owners := make([]keyRangeOwner, len(c.Partitions))
+	for i, p := range c.Partitions {
+		start, end, _ := p.KeyRange.runes()
+		owners[i] = keyRangeOwner{p.Name, start, end}
+	}
+	return checkKeyRanges(owners)
+}
+
+// runes returns the start and end keys of the range as upper case runes.
+func (r *KeyRange) runes() (rune, rune, error) {
+	start, err := parsePartitionKey(r.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("key_range start: %v", err)
+	}
+	end, err := parsePartitionKey(r.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("key_range end: %v", err)
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("key_range start %c is after end %c", start, end)
+	}
+	return start, end, nil
+}
+
+// parsePartitionKey converts a single character config value into a partition key.
+func parsePartitionKey(s string) (rune, error) {
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("%q must be a single character", s)
+	}
+	r := []rune(strings.ToUpper(s))[0]
+	if r < partitionKeySpaceStart || r > partitionKeySpaceEnd {
+		return 0, fmt.Errorf("%q is outside the partition key space %c-%c", s, partitionKeySpaceStart, partitionKeySpaceEnd)
+	}
+	return r, nil
+}
+
+// coordinatorName returns the name of the coordinator partition: the one named in the
+// config, or the first partition.
+func (c *PartitionConfig) coordinatorName() string {
+	if c.CoordinatorPartition != "" || len(c.Partitions) == 0 {
+		return c.CoordinatorPartition
+	}
+	return c.Partitions[0].Name
+}
+
+// OpenDatabases opens a Database for every partition in the config in its configured
+// dialect, applying the transaction lock mode, journal mode, busy timeout, pool and circuit
+// breaker settings, and starts replicating to any configured read replicas.
+func (c *PartitionConfig) OpenDatabases() ([]*Database, error) {
+	dbs := make([]*Database, 0, len(c.Partitions))
+	for _, p := range c.Partitions {
+		dsn := p.DSN
+		if p.TxLock != "" {
+			dsn = DSNWithTxLock(dsn, p.TxLock)
+		}
+		if p.JournalMode != "" {
+			dsn = DSNWithJournalMode(dsn, p.JournalMode)
+		}
+		if p.BusyTimeout > 0 {
+			dsn = DSNWithBusyTimeout(dsn, time.Duration(p.BusyTimeout))
+		}
+
+		var start, end rune
+		if p.KeyRange != nil {
+			var err error
+			start, end, err = p.KeyRange.runes()
+			if err != nil {
+				closeDatabases(dbs)
+				return nil, err
+			}
+		}
+
+		dialect, err := dialectByName(p.Dialect)
+		if err != nil {
+			closeDatabases(dbs)
+			return nil, err
+		}
+
+		db, err := NewDatabaseWithDialect(dialect, p.Name, dsn, start, end)
+		if err != nil {
+			closeDatabases(dbs)
+			return nil, err
+		}
+		db.HashWeight = p.HashWeight
+		db.Coordinator = p.Name == c.coordinatorName()
+		if p.Pool.SplitReadWrite {
+			if err := db.OpenReadPool(dsn); err != nil {
+				db.Close()
+				closeDatabases(dbs)
+				return nil, err
+			}
+		}
+		db.ConfigurePool(p.Pool)
+		db.ConfigureCircuitBreaker(CircuitBreakerConfig{
+			FailureThreshold:    p.CircuitBreaker.FailureThreshold,
+			OpenTimeout:         time.Duration(p.CircuitBreaker.OpenTimeout),
+			HalfOpenMaxRequests: p.CircuitBreaker.HalfOpenMaxRequests,
+		})
+		dbs = append(dbs, db)
+
+		db.MaxReadStaleness = time.Duration(p.MaxReadStaleness)
+		for _, r := range p.Replicas {
+			replica, err := NewReplica(r.Name, r.DSN)
+			if err != nil {
+				closeDatabases(dbs)
+				return nil, err
+			}
+			db.AddReplica(replica)
+		}
+
+		interval := time.Duration(p.ReplicationInterval)
+		if interval == 0 {
+			interval = defaultReplicationInterval
+		}
+		db.StartReplication(interval)
+	}
+	return dbs, nil
+}
+
+// closeDatabases closes databases opened before a later partition failed to open.
+func closeDatabases(dbs []*Database) {
+	for i := range dbs {
+		dbs[i].Close()
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestPartitionConfig
+// Run sub test:  	go test -run TestPartitionConfig/TestValidateDetectsGaps
+func TestPartitionConfig(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TESTS //
+	t.Run("TestLoadPartitionConfig", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg, err := LoadPartitionConfig("partitions.json")
+		if err != nil {
+			t.Fatalf("Expected config to load, received error: %v", err)
+		}
+		if len(cfg.Partitions) != 2 {
+			t.Errorf("Expected 2 partitions, received %d", len(cfg.Partitions))
+		}
+	})
+
+	t.Run("TestValidateDetectsGaps", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := rangeConfig([2]string{"B", "K"}, [2]string{"N", "Y"})
+		err := cfg.Validate()
+		if err == nil {
+			t.Fatal("Expected gap error, received nil")
+		}
+		for _, gap := range []string{"key A ", "keys L-M", "key Z "} {
+			if !strings.Contains(err.Error(), gap) {
+				t.Errorf("Expected gap %s to be reported, received: %v", gap, err)
+			}
+		}
+	})
+
+	t.Run("TestValidateDetectsOverlaps", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := rangeConfig([2]string{"A", "P"}, [2]string{"C", "D"}, [2]string{"M", "Z"})
+		err := cfg.Validate()
+		if err == nil {
+			t.Fatal("Expected overlap error, received nil")
+		}
+		for _, overlap := range []string{"keys C-D", "keys M-P"} {
+			if !strings.Contains(err.Error(), overlap) {
+				t.Errorf("Expected overlap %s to be reported, received: %v", overlap, err)
+			}
+		}
+	})
+
+	t.Run("TestValidateRejectsMixedStrategies", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := rangeConfig([2]string{"A", "Z"})
+		cfg.Partitions = append(cfg.Partitions, PartitionDefinition{Name: "hashed", DSN: "hashed.db", HashWeight: 1})
+		if err := cfg.Validate(); err == nil {
+			t.Error("Expected error mixing key_range and hash_weight, received nil")
+		}
+	})
+
+	t.Run("TestValidateSplitRequiresWAL", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := rangeConfig([2]string{"A", "Z"})
+		cfg.Partitions[0].Pool.SplitReadWrite = true
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "requires journal_mode wal") {
+			t.Errorf("Expected split_read_write without wal to be rejected, received: %v", err)
+		}
+
+		cfg.Partitions[0].JournalMode = "wall"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "invalid journal_mode") {
+			t.Errorf("Expected invalid journal_mode to be rejected, received: %v", err)
+		}
+
+		cfg.Partitions[0].JournalMode = "WAL"
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected split_read_write with wal to be valid, received: %v", err)
+		}
+	})
+
+	t.Run("TestValidateCoordinatorPartition", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := rangeConfig([2]string{"A", "M"}, [2]string{"N", "Z"})
+		cfg.CoordinatorPartition = "missing.db"
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `coordinator_partition "missing.db"`) {
+			t.Errorf("Expected an unknown coordinator partition to be rejected, received: %v", err)
+		}
+
+		cfg.CoordinatorPartition = cfg.Partitions[1].Name
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected a coordinator partition in the config to be valid, received: %v", err)
+		}
+	})
+
+	t.Run("TestHashWeightsCoverKeySpace", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := PartitionConfig{Partitions: []PartitionDefinition{
+			{Name: "h1.db", DSN: "file:h1?mode=memory", HashWeight: 1},
+			{Name: "h2.db", DSN: "file:h2?mode=memory", HashWeight: 3},
+		}}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Expected valid config, received error: %v", err)
+		}
+		dbs, err := cfg.OpenDatabases()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm, err := NewPartitionManager(dbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pm.CloseConnections()
+		for x := partitionKeySpaceStart; x <= partitionKeySpaceEnd; x++ {
+			if pm.GetDatabaseByPartitionKey(x) == nil {
+				t.Errorf("Expected key %c to be routed to a partition", x)
+			}
+		}
+	})
+}
+
+// HELPER FUNCTIONS //
+func rangeConfig(ranges ...[2]string) PartitionConfig {
+	var cfg PartitionConfig
+	for i, r := range ranges {
+		name := fmt.Sprintf("enrollment%d.db", i+1)
+		cfg.Partitions = append(cfg.Partitions, PartitionDefinition{
+			Name:     name,
+			DSN:      "./" + name,
+			KeyRange: &KeyRange{Start: r[0], End: r[1]},
+		})
+	}
+	return cfg
+}
@@ -1,37 +1,311 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// TxLock controls how sqlite acquires locks when a transaction begins.
+type TxLock string
+
+const (
+	// TxLockDeferred acquires locks lazily on first read/write (sqlite default).
+	TxLockDeferred TxLock = "deferred"
+	// TxLockImmediate acquires the write lock as soon as the transaction begins,
+	// so competing writers fail fast with SQLITE_BUSY instead of deadlocking mid-transaction.
+	TxLockImmediate TxLock = "immediate"
+	// TxLockExclusive prevents other connections from reading or writing during the transaction.
+	TxLockExclusive TxLock = "exclusive"
+)
+
+const (
+	defaultTxRetries    = 5
+	defaultTxRetryDelay = 50 * time.Millisecond
 )
 
 type Database struct {
-	Name           string
-	db             *sql.DB
+	Name string
+	db   *sql.DB
+	// readDB is an optional read-only pool for reads from the primary; see OpenReadPool.
+	readDB         *sql.DB
 	PartitionStart rune
 	PartitionEnd   rune
+	// TxRetries is the number of times WithTx retries a unit of work that failed
+	// because the database was busy or locked.
+	TxRetries int
+	// TxRetryDelay is the initial delay between retries; it doubles after each attempt.
+	TxRetryDelay time.Duration
+	// HashWeight is this partition's share of the hashed key space when partitions
+	// are routed by hash instead of by PartitionStart/PartitionEnd. Zero means the
+	// partition is routed by key range.
+	HashWeight int
+	// Coordinator marks the partition that holds the seat counters and the global
+	// indexes; see GetSeatCounterDatabase and GetIndexDatabase.
+	Coordinator bool
+	// MaxReadStaleness is how far behind the primary a replica may be and still
+	// serve reads. Zero allows any replica that has been synced at least once.
+	MaxReadStaleness time.Duration
+	// Dialect is the backend the partition is stored in.
+	Dialect Dialect
+	// dsn is the connection string the partition was opened with.
+	dsn string
+
+	replicas        []*Replica
+	nextReplica     uint32
+	stopReplication chan struct{}
+	replicationDone chan struct{}
+	health          healthState
+	breaker         circuitBreaker
+	stmts           stmtCache
 }
 
+// NewDatabase opens a partition stored in a sqlite file.
 func NewDatabase(name string, connectionString string, partitionStart rune, partitionEnd rune) (*Database, error) {
-	db, err := sql.Open("sqlite3", connectionString)
+	return NewDatabaseWithDialect(SQLiteDialect, name, connectionString, partitionStart, partitionEnd)
+}
+
+// NewDatabaseWithDialect opens a partition stored in the given backend.
+func NewDatabaseWithDialect(dialect Dialect, name string, connectionString string, partitionStart rune, partitionEnd rune) (*Database, error) {
+	db, err := sql.Open(dialect.DriverName(), connectionString)
 	if err != nil {
 		log.Println(err.Error())
 		return nil, err
 	}
 
-	return &Database{
+	d := &Database{
 		Name:           name,
 		db:             db,
 		PartitionStart: unicode.ToUpper(partitionStart),
 		PartitionEnd:   unicode.ToUpper(partitionEnd),
-	}, nil
+		TxRetries:      defaultTxRetries,
+		TxRetryDelay:   defaultTxRetryDelay,
+		Dialect:        dialect,
+		dsn:            connectionString,
+	}
+	d.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+	if dialect == PostgresDialect {
+		log.Printf("Partition %s uses the experimental postgres dialect", name)
+	}
+	return d, nil
+}
+
+// DSNWithTxLock appends the sqlite _txlock parameter to a connection string so
+// every transaction started on the connection begins with the given lock mode.
+func DSNWithTxLock(connectionString string, lock TxLock) string {
+	return dsnWithParam(connectionString, "_txlock", string(lock))
+}
+
+// DSNWithJournalMode appends the sqlite journal_mode pragma to a connection
+// string, e.g. "wal" so readers do not block the writer and the writer does not
+// block readers.
+func DSNWithJournalMode(connectionString string, mode string) string {
+	key, value := sqliteParam("journal_mode", strings.ToUpper(mode))
+	return dsnWithParam(connectionString, key, value)
+}
+
+// DSNWithBusyTimeout appends the sqlite busy_timeout pragma to a connection
+// string, so a connection that finds the database locked waits up to d for the lock
+// before failing with SQLITE_BUSY.
+func DSNWithBusyTimeout(connectionString string, d time.Duration) string {
+	key, value := sqliteParam("busy_timeout", fmt.Sprintf("%d", d.Milliseconds()))
+	return dsnWithParam(connectionString, key, value)
+}
+
+// sqliteFilePath returns the path of the file a sqlite connection string opens, and
+// reports whether it opens a file rather than an in-memory database.
+func sqliteFilePath(connectionString string) (string, bool) {
+	path := strings.TrimPrefix(connectionString, "file:")
+	query := ""
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	if path == "" || path == ":memory:" || strings.Contains(query, "mode=memory") {
+		return "", false
+	}
+	return path, true
+}
+
+func dsnWithParam(connectionString string, key string, value string) string {
+	sep := "?"
+	if strings.Contains(connectionString, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s%s=%s", connectionString, sep, key, value)
+}
+
+// OpenReadPool opens a second, read-only connection pool to the partition that is
+// used for reads from the primary, so the main pool can be limited to the single
+// connection sqlite allows to write at a time. It should only be used with the WAL
+// journal mode; otherwise the readers block the writer.
+func (i *Database) OpenReadPool(connectionString string) error {
+	key, value := sqliteParam("query_only", "true")
+	db, err := sql.Open(sqliteDriverName, dsnWithParam(connectionString, key, value))
+	if err != nil {
+		return err
+	}
+	i.readDB = db
+	return nil
+}
+
+// ConfigurePool applies connection pool settings to the partition's connection pool.
+// Zero values leave the database/sql defaults in place. If the partition has a read
+// pool, the settings apply to the read pool and the write pool is limited to one
+// connection.
+func (i *Database) ConfigurePool(p PoolConfig) {
+	pool := i.db
+	if i.readDB != nil {
+		i.db.SetMaxOpenConns(1)
+		i.db.SetMaxIdleConns(1)
+		pool = i.readDB
+	}
+
+	if p.MaxOpenConns > 0 {
+		pool.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	for _, db := range []*sql.DB{i.db, i.readDB} {
+		if db == nil {
+			continue
+		}
+		if p.ConnMaxLifetime > 0 {
+			db.SetConnMaxLifetime(time.Duration(p.ConnMaxLifetime))
+		}
+		if p.ConnMaxIdleTime > 0 {
+			db.SetConnMaxIdleTime(time.Duration(p.ConnMaxIdleTime))
+		}
+	}
+}
+
+// primaryReader returns the pool used to read from the primary: the read pool if
+// the partition has one, and the main pool otherwise.
+func (i *Database) primaryReader() *sql.DB {
+	if i.readDB != nil {
+		return i.readDB
+	}
+	return i.db
+}
+
+// PartitionStats holds the connection pool statistics of a partition.
+type PartitionStats struct {
+	Name string
+	// Writer is the main pool, used for every write and, without a read pool, for reads.
+	Writer sql.DBStats
+	// Reader is the read pool, if the partition has one.
+	Reader *sql.DBStats
+	// Replicas maps each replica name to its pool statistics.
+	Replicas map[string]sql.DBStats
+}
+
+// Stats returns the partition's connection pool statistics.
+func (i *Database) Stats() PartitionStats {
+	s := PartitionStats{Name: i.Name, Writer: i.db.Stats()}
+	if i.readDB != nil {
+		r := i.readDB.Stats()
+		s.Reader = &r
+	}
+	if len(i.replicas) > 0 {
+		s.Replicas = make(map[string]sql.DBStats, len(i.replicas))
+		for _, r := range i.replicas {
+			s.Replicas[r.Name] = r.db.Stats()
+		}
+	}
+	return s
 }
 
 func (i *Database) GetConnection() *sql.DB {
 	return i.db
 }
 
+// WithTx runs fn inside a transaction on this partition. The transaction is
+// committed if fn returns nil and rolled back otherwise. If the transaction
+// fails because the database is busy or locked, the whole unit of work is
+// retried with exponential backoff, so fn must be safe to run more than once.
+// If the health monitor has marked the partition down, ErrPartitionUnavailable is
+// returned without starting a transaction, and if the partition's circuit breaker is
+// open, ErrCircuitOpen is.
+func (i *Database) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	// writes can only go to the primary, so fail fast rather than wait out the timeout
+	if !i.Available() {
+		return i.unavailableError()
+	}
+
+	return i.withBreaker(func() error {
+		delay := i.TxRetryDelay
+		for attempt := 0; ; attempt++ {
+			err := i.runTx(ctx, fn)
+			if err == nil || !isBusyError(err) || attempt >= i.TxRetries {
+				return err
+			}
+
+			log.Printf("Partition %s busy, retrying transaction (attempt %d of %d)", i.Name, attempt+1, i.TxRetries)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	})
+}
+
+// runTx executes a single attempt of a WithTx unit of work.
+func (i *Database) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			log.Printf("Unable to roll back transaction on %s: %v", i.Name, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isBusyError reports whether err was caused by the database being unable to obtain
+// a lock: SQLITE_BUSY or SQLITE_LOCKED for sqlite, and a serialization failure,
+// deadlock or lock timeout for PostgreSQL.
+func isBusyError(err error) bool {
+	if code, ok := sqliteErrorCode(err); ok {
+		return code == sqliteBusy || code == sqliteLocked
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", "55P03":
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops replication, closes the cached statements, and closes the partition's
+// primary and replica connections.
 func (i *Database) Close() {
+	if i.stopReplication != nil {
+		close(i.stopReplication)
+		<-i.replicationDone
+		i.stopReplication = nil
+	}
+	for _, r := range i.replicas {
+		r.Close()
+	}
+	i.closeStatements()
+	if i.readDB != nil {
+		i.readDB.Close()
+	}
 	i.db.Close()
 }
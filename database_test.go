@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestDatabase
+// Run sub test:  	go test -run TestDatabase/TestWithTxCommit
+func TestDatabase(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	dir, err := ioutil.TempDir("", "enrollment")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDatabase("test.db", DSNWithTxLock(filepath.Join(dir, "test.db"), TxLockImmediate), 65, 90)
+	if err != nil {
+		t.Fatalf("Expected to receive database, received error: %v\n", err)
+	}
+	if _, err := db.db.Exec(`CREATE TABLE courses (code TEXT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("Expected to create table, received error: %v\n", err)
+	}
+
+	// TESTS //
+	t.Run("TestWithTxCommit", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		err := db.WithTx(context.Background(), func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO courses VALUES ('DB101', 'Databases 101')`)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Expected transaction to commit, received error: %v", err)
+		}
+
+		var cnt int
+		if err := db.db.QueryRow(`SELECT COUNT(*) FROM courses WHERE code = 'DB101'`).Scan(&cnt); err != nil {
+			t.Fatal(err)
+		}
+		if cnt != 1 {
+			t.Errorf("Expected 1 committed row, received: %d", cnt)
+		}
+	})
+
+	t.Run("TestWithTxRollback", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		errBoom := errors.New("boom")
+		err := db.WithTx(context.Background(), func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`INSERT INTO courses VALUES ('ML301', 'Machine Learning 301')`); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("Expected error from unit of work, received: %v", err)
+		}
+
+		var cnt int
+		if err := db.db.QueryRow(`SELECT COUNT(*) FROM courses WHERE code = 'ML301'`).Scan(&cnt); err != nil {
+			t.Fatal(err)
+		}
+		if cnt != 0 {
+			t.Errorf("Expected row to be rolled back, found: %d", cnt)
+		}
+	})
+
+	t.Run("TestPreparedStatementCache", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		query := `INSERT INTO courses(code, name) VALUES (?, ?)`
+
+		stmts := make([]*sql.Stmt, 8)
+		var wg sync.WaitGroup
+		for k := range stmts {
+			wg.Add(1)
+			go func(k int) {
+				defer wg.Done()
+				s, err := db.prepared(context.Background(), query)
+				if err != nil {
+					t.Errorf("Expected statement to be prepared, received error: %v", err)
+				}
+				stmts[k] = s
+			}(k)
+		}
+		wg.Wait()
+		for k := range stmts {
+			if stmts[k] != stmts[0] {
+				t.Fatal("Expected every caller to receive the same cached statement")
+			}
+		}
+
+		err := db.WithTx(context.Background(), func(tx *sql.Tx) error {
+			_, err := tx.Stmt(stmts[0]).Exec("ALGO201", "Algorithms 201")
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Expected cached statement to run in a transaction, received error: %v", err)
+		}
+
+		db.closeStatements()
+		if len(db.stmts.stmts) != 0 {
+			t.Errorf("Expected cache to be empty after closing, found %d statements", len(db.stmts.stmts))
+		}
+		if s, err := db.prepared(context.Background(), query); err != nil || s == stmts[0] {
+			t.Errorf("Expected statement to be prepared again after closing, error: %v", err)
+		}
+	})
+
+	t.Run("TestSplitReadWritePools", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := PartitionConfig{Partitions: []PartitionDefinition{{
+			Name:        "split.db",
+			DSN:         filepath.Join(dir, "split.db"),
+			TxLock:      TxLockImmediate,
+			KeyRange:    &KeyRange{"A", "Z"},
+			JournalMode: "wal",
+			BusyTimeout: Duration(time.Second),
+			Pool:        PoolConfig{MaxOpenConns: 4, SplitReadWrite: true},
+		}}}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Expected valid config, received error: %v", err)
+		}
+		dbs, err := cfg.OpenDatabases()
+		if err != nil {
+			t.Fatalf("Expected database to open, received error: %v", err)
+		}
+		split := dbs[0]
+		defer split.Close()
+
+		var mode string
+		if err := split.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+			t.Fatalf("Expected wal journal mode, received: %s, error: %v", mode, err)
+		}
+
+		err = split.WithTx(context.Background(), func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE courses (code TEXT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO courses VALUES ('DB101', 'Databases 101')`)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Expected write through the writer pool, received error: %v", err)
+		}
+
+		reader, err := split.Reader()
+		if err != nil || reader != split.readDB {
+			t.Fatalf("Expected reads to use the read pool, error: %v", err)
+		}
+		var name string
+		if err := reader.QueryRow(`SELECT name FROM courses WHERE code = 'DB101'`).Scan(&name); err != nil || name != "Databases 101" {
+			t.Errorf("Expected read pool to see the committed write, received: %s, error: %v", name, err)
+		}
+		if _, err := reader.Exec(`INSERT INTO courses VALUES ('ML301', 'Machine Learning 301')`); err == nil {
+			t.Error("Expected read pool to reject writes, received nil")
+		}
+
+		stats := split.Stats()
+		if stats.Writer.MaxOpenConnections != 1 {
+			t.Errorf("Expected a single writer connection, received max %d", stats.Writer.MaxOpenConnections)
+		}
+		if stats.Reader == nil || stats.Reader.MaxOpenConnections != 4 {
+			t.Errorf("Expected read pool stats with max 4 connections, received: %+v", stats.Reader)
+		}
+	})
+
+	t.Run("TestWithTxRetriesUntilLockReleased", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		busy, locker := openLockedPair(t, dir, "released.db")
+		defer busy.Close()
+		defer locker.Close()
+		busy.TxRetries = 5
+		busy.TxRetryDelay = 20 * time.Millisecond
+
+		// a second connection holds the write lock and releases it after a delay
+		lock, err := locker.db.Begin()
+		if err != nil {
+			t.Fatalf("Expected the second connection to take the write lock, received error: %v", err)
+		}
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			lock.Rollback()
+		}()
+
+		attempts := 0
+		err = busy.WithTx(context.Background(), func(tx *sql.Tx) error {
+			attempts++
+			_, err := tx.Exec(`INSERT INTO courses VALUES ('DB101', 'Databases 101')`)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Expected the transaction to succeed once the lock was released, received error: %v", err)
+		}
+		if attempts != 1 {
+			t.Errorf("Expected the unit of work to run once the lock was taken, ran %d times", attempts)
+		}
+	})
+
+	t.Run("TestWithTxGivesUpAfterRetries", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		busy, locker := openLockedPair(t, dir, "held.db")
+		defer busy.Close()
+		defer locker.Close()
+		busy.TxRetries = 2
+		busy.TxRetryDelay = 10 * time.Millisecond
+
+		// the lock is held for longer than the retries wait: 10ms + 20ms
+		lock, err := locker.db.Begin()
+		if err != nil {
+			t.Fatalf("Expected the second connection to take the write lock, received error: %v", err)
+		}
+		defer lock.Rollback()
+
+		start := time.Now()
+		err = busy.WithTx(context.Background(), func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO courses VALUES ('DB101', 'Databases 101')`)
+			return err
+		})
+		if !isBusyError(err) {
+			t.Fatalf("Expected a busy error once the retries ran out, received: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+			t.Errorf("Expected the retries to wait at least 30ms, gave up after %s", elapsed)
+		}
+	})
+
+	// TEST TEAR DOWN //
+	db.Close()
+}
+
+// HELPER FUNCTIONS //
+
+// openLockedPair opens two connections to the same new sqlite file with a courses
+// table: one to run WithTx on, which does not wait for locks so only WithTx's retries
+// do, and one to hold the write lock with.
+func openLockedPair(t *testing.T, dir string, file string) (*Database, *Database) {
+	dsn := DSNWithBusyTimeout(DSNWithTxLock(filepath.Join(dir, file), TxLockImmediate), 0)
+	busy, err := NewDatabase(file, dsn, 65, 90)
+	if err != nil {
+		t.Fatalf("Expected to receive database, received error: %v\n", err)
+	}
+	if _, err := busy.db.Exec(`CREATE TABLE courses (code TEXT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatalf("Expected to create table, received error: %v\n", err)
+	}
+	locker, err := NewDatabase("locker", dsn, 65, 90)
+	if err != nil {
+		t.Fatalf("Expected to receive database, received error: %v\n", err)
+	}
+	return busy, locker
+}
@@ -1,8 +1,36 @@
 -- assume sqlite db for this example
+-- Keep in sync with createTables in build.go, which creates these in every partition.
 
 CREATE TABLE IF NOT EXISTS courses (
     code TEXT PRIMARY KEY,
-    name TEXT NOT NULL
+    name TEXT NOT NULL,
+    capacity INTEGER -- NULL means unlimited
+) WITHOUT ROWID;
+
+-- Courses that must be passed before enrolling in a course. Replicated like courses.
+CREATE TABLE IF NOT EXISTS course_prerequisites (
+    course_code TEXT NOT NULL,
+    prerequisite_code TEXT NOT NULL,
+    PRIMARY KEY (course_code, prerequisite_code),
+    FOREIGN KEY (course_code) REFERENCES courses (code),
+    FOREIGN KEY (prerequisite_code) REFERENCES courses (code)
+) WITHOUT ROWID;
+
+-- Academic terms (semesters). Replicated like courses.
+CREATE TABLE IF NOT EXISTS terms (
+    code TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    start_date INTEGER NOT NULL, -- Epoch time (seconds)
+    end_date INTEGER NOT NULL -- Epoch time (seconds)
+) WITHOUT ROWID;
+
+-- Courses offered in each term. Replicated like courses.
+CREATE TABLE IF NOT EXISTS course_offerings (
+    term_code TEXT NOT NULL,
+    course_code TEXT NOT NULL,
+    PRIMARY KEY (term_code, course_code),
+    FOREIGN KEY (term_code) REFERENCES terms (code),
+    FOREIGN KEY (course_code) REFERENCES courses (code)
 ) WITHOUT ROWID;
 
 CREATE TABLE IF NOT EXISTS students (
@@ -11,17 +39,59 @@ CREATE TABLE IF NOT EXISTS students (
     mobile TEXT
 );
 
+-- Index the lower case name to help with case-insensitive searches by name prefix
+CREATE INDEX students_name_lower ON students(LOWER(name));
+
+-- Index the mobile to help look students up by mobile number
+CREATE INDEX students_mobile ON students(mobile);
+
 CREATE TABLE IF NOT EXISTS enrollment (
     student_id INTEGER NOT NULL,
     course_code TEXT NOT NULL,
+    term_code TEXT NOT NULL,
     date_enrolled INTEGER NOT NULL, -- Epoch time (seconds)
     final_grade TEXT,
-    PRIMARY KEY (student_id, course_code),
+    PRIMARY KEY (student_id, course_code, term_code),
     FOREIGN KEY (student_id) REFERENCES students (id),
-    FOREIGN KEY (course_code) REFERENCES sources (code)
+    FOREIGN KEY (course_code) REFERENCES courses (code),
+    FOREIGN KEY (term_code) REFERENCES terms (code)
 ) WITHOUT ROWID;
 
 -- Index the date_enrolled table to help with filters on date/time
 CREATE INDEX enrollment_date_enrolled ON enrollment(date_enrolled);
 
+-- Index the term_code to help list a student's courses for a term
+CREATE INDEX enrollment_term_code ON enrollment(term_code, student_id);
+
+-- Students waiting for a seat in a full course. Stored in the student's partition.
+CREATE TABLE IF NOT EXISTS waitlist (
+    student_id INTEGER NOT NULL,
+    course_code TEXT NOT NULL,
+    term_code TEXT NOT NULL,
+    date_added INTEGER NOT NULL, -- Epoch time (seconds) to order the queue across partitions
+    PRIMARY KEY (student_id, course_code, term_code),
+    FOREIGN KEY (student_id) REFERENCES students (id),
+    FOREIGN KEY (course_code) REFERENCES courses (code),
+    FOREIGN KEY (term_code) REFERENCES terms (code)
+) WITHOUT ROWID;
 
+-- Index the waitlist by course and date_added to find the next student to promote
+CREATE INDEX waitlist_course_date_added ON waitlist(term_code, course_code, date_added);
+
+-- Seats taken in each capacity-limited course per term, counted across all partitions.
+-- Only the coordinator partition (coordinator_partition in partitions.json) uses this table.
+CREATE TABLE IF NOT EXISTS course_seats (
+    term_code TEXT NOT NULL,
+    course_code TEXT NOT NULL,
+    enrolled INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (term_code, course_code)
+) WITHOUT ROWID;
+
+-- Maps each mobile number to the partition and id of the student who uses it, so
+-- mobile numbers are unique across partitions and can be looked up in one hop.
+-- Only the coordinator partition (coordinator_partition in partitions.json) uses this table.
+CREATE TABLE IF NOT EXISTS student_mobiles (
+    mobile TEXT PRIMARY KEY,
+    partition_name TEXT NOT NULL,
+    student_id INTEGER NOT NULL -- 0 until the student has been written
+) WITHOUT ROWID;
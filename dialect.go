@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// Dialect captures the differences between the database backends a partition can
+// be stored in. Queries throughout the app are written for sqlite, with ? placeholders,
+// and are translated by the partition's dialect before they run.
+type Dialect interface {
+	// Name is the name used to select the dialect in the partition config.
+	Name() string
+	// DriverName is the database/sql driver used to open the partition.
+	DriverName() string
+	// Rebind rewrites the ? placeholders in query into the dialect's placeholder style.
+	Rebind(query string) string
+	// DDL rewrites a CREATE TABLE or CREATE INDEX statement written for sqlite.
+	DDL(stmt string) string
+	// Upsert returns an INSERT of columns into table that, when a row with the same
+	// conflict columns already exists, updates the update columns instead, or does
+	// nothing if update is empty.
+	Upsert(table string, columns []string, conflict []string, update []string) string
+	// InsertReturningID rewrites an INSERT so that insertID can read the generated
+	// value of the id column.
+	InsertReturningID(query string, id string) string
+
+	// insertID runs a statement prepared from InsertReturningID and returns the
+	// generated id.
+	insertID(ctx context.Context, stmt *sql.Stmt, args ...interface{}) (int64, error)
+	// healthCheckQuery is a cheap query that fails if the partition cannot be read.
+	healthCheckQuery() string
+}
+
+// SQLiteDialect stores a partition in a sqlite file. It is the default.
+var SQLiteDialect Dialect = sqliteDialect{}
+
+// PostgresDialect stores a partition in a PostgreSQL database. It is experimental: it
+// is only exercised by TestDialects/TestMixedBackendPartitions where a PostgreSQL
+// server is available to the tests.
+var PostgresDialect Dialect = postgresDialect{}
+
+// dialectByName returns the dialect selected in the partition config.
+func dialectByName(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "", "sqlite", "sqlite3":
+		return SQLiteDialect, nil
+	case "postgres", "postgresql":
+		return PostgresDialect, nil
+	}
+	return nil, fmt.Errorf("unknown dialect %q", name)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string       { return "sqlite3" }
+func (sqliteDialect) DriverName() string { return sqliteDriverName }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) DDL(stmt string) string { return stmt }
+
+func (sqliteDialect) Upsert(table string, columns []string, conflict []string, update []string) string {
+	if len(update) == 0 {
+		return fmt.Sprintf("INSERT OR IGNORE INTO %s(%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders(len(columns)))
+	}
+	return upsertOnConflict(table, columns, conflict, update)
+}
+
+func (sqliteDialect) InsertReturningID(query string, id string) string { return query }
+
+func (sqliteDialect) insertID(ctx context.Context, stmt *sql.Stmt, args ...interface{}) (int64, error) {
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (sqliteDialect) healthCheckQuery() string { return `SELECT COUNT(*) FROM sqlite_master` }
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string       { return "postgres" }
+func (postgresDialect) DriverName() string { return "postgres" }
+
+// Rebind numbers the placeholders $1, $2, ... skipping any ? inside string literals.
+func (postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// postgresDDL maps the sqlite-only parts of the schema to PostgreSQL. sqlite integers
+// are 64-bit, so every INTEGER becomes a BIGINT.
+var postgresDDL = strings.NewReplacer(
+	"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY",
+	"INTEGER", "BIGINT",
+	") WITHOUT ROWID;", ");",
+)
+
+func (postgresDialect) DDL(stmt string) string { return postgresDDL.Replace(stmt) }
+
+func (postgresDialect) Upsert(table string, columns []string, conflict []string, update []string) string {
+	if len(update) == 0 {
+		return fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s) ON CONFLICT (%s) DO NOTHING",
+			table, strings.Join(columns, ", "), placeholders(len(columns)), strings.Join(conflict, ", "))
+	}
+	return upsertOnConflict(table, columns, conflict, update)
+}
+
+func (postgresDialect) InsertReturningID(query string, id string) string {
+	return fmt.Sprintf("%s RETURNING %s", strings.TrimRight(strings.TrimSpace(query), ";"), id)
+}
+
+func (postgresDialect) insertID(ctx context.Context, stmt *sql.Stmt, args ...interface{}) (int64, error) {
+	var id int64
+	err := stmt.QueryRowContext(ctx, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) healthCheckQuery() string { return `SELECT 1` }
+
+// upsertOnConflict builds the INSERT ... ON CONFLICT ... DO UPDATE form that sqlite
+// and PostgreSQL share.
+func upsertOnConflict(table string, columns []string, conflict []string, update []string) string {
+	set := make([]string, len(update))
+	for k, c := range update {
+		set[k] = fmt.Sprintf("%s = excluded.%s", c, c)
+	}
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(columns, ", "), placeholders(len(columns)), strings.Join(conflict, ", "), strings.Join(set, ", "))
+}
+
+// placeholders returns n comma separated ? placeholders.
+func placeholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return "?" + strings.Repeat(", ?", n-1)
+}
+
+// rebind translates a query written with ? placeholders for the partition's dialect.
+func (i *Database) rebind(query string) string {
+	return i.Dialect.Rebind(query)
+}
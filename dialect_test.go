@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestDialects
+// Run sub test:  	go test -run TestDialects/TestPostgresRebind
+//
+// TestDialects/TestMixedBackendPartitions starts a throwaway PostgreSQL server from the
+// initdb and postgres binaries on the PATH, in PG_BIN or in /usr/lib/postgresql, and is
+// skipped if there are none. To use an existing server instead, set
+// ENROLLMENT_POSTGRES_DSN, e.g.
+// ENROLLMENT_POSTGRES_DSN="postgres://postgres@localhost/enrollment?sslmode=disable" go test -run TestDialects
+func TestDialects(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TESTS //
+	t.Run("TestDialectByName", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		for name, want := range map[string]Dialect{"": SQLiteDialect, "sqlite3": SQLiteDialect, "postgres": PostgresDialect} {
+			if d, err := dialectByName(name); err != nil || d != want {
+				t.Errorf("Expected %s for %q, received: %v, error: %v", want.Name(), name, d, err)
+			}
+		}
+		if _, err := dialectByName("oracle"); err == nil {
+			t.Error("Expected unknown dialect to be rejected, received nil")
+		}
+	})
+
+	t.Run("TestPostgresRebind", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		query := `SELECT id FROM students WHERE name = ? AND mobile <> '?' AND id IN (?, ?)`
+		want := `SELECT id FROM students WHERE name = $1 AND mobile <> '?' AND id IN ($2, $3)`
+		if got := PostgresDialect.Rebind(query); got != want {
+			t.Errorf("Expected %s, received: %s", want, got)
+		}
+		if got := SQLiteDialect.Rebind(query); got != query {
+			t.Errorf("Expected sqlite to keep ? placeholders, received: %s", got)
+		}
+	})
+
+	t.Run("TestPostgresDDL", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		ddl := PostgresDialect.DDL(`CREATE TABLE IF NOT EXISTS students (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			date_added INTEGER NOT NULL
+		) WITHOUT ROWID;`)
+		for _, s := range []string{"id BIGSERIAL PRIMARY KEY", "date_added BIGINT NOT NULL", ");"} {
+			if !strings.Contains(ddl, s) {
+				t.Errorf("Expected DDL to contain %q, received: %s", s, ddl)
+			}
+		}
+		for _, s := range []string{"AUTOINCREMENT", "WITHOUT ROWID"} {
+			if strings.Contains(ddl, s) {
+				t.Errorf("Expected sqlite-only %s to be removed, received: %s", s, ddl)
+			}
+		}
+	})
+
+	t.Run("TestUpsert", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cols := []string{"term_code", "course_code", "enrolled"}
+		key := []string{"term_code", "course_code"}
+
+		tests := []struct {
+			dialect Dialect
+			update  []string
+			want    string
+		}{
+			{SQLiteDialect, nil, "INSERT OR IGNORE INTO course_seats(term_code, course_code, enrolled) VALUES (?, ?, ?)"},
+			{PostgresDialect, nil, "INSERT INTO course_seats(term_code, course_code, enrolled) VALUES (?, ?, ?) ON CONFLICT (term_code, course_code) DO NOTHING"},
+			{PostgresDialect, []string{"enrolled"}, "INSERT INTO course_seats(term_code, course_code, enrolled) VALUES (?, ?, ?) ON CONFLICT (term_code, course_code) DO UPDATE SET enrolled = excluded.enrolled"},
+		}
+		for _, tt := range tests {
+			if got := tt.dialect.Upsert("course_seats", cols, key, tt.update); got != tt.want {
+				t.Errorf("Expected %s upsert %s, received: %s", tt.dialect.Name(), tt.want, got)
+			}
+		}
+	})
+
+	t.Run("TestValidateRejectsSqliteSettingsForPostgres", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := rangeConfig([2]string{"A", "Z"})
+		cfg.Partitions[0].Dialect = "postgres"
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Expected postgres partition to be valid, received: %v", err)
+		}
+
+		cfg.Partitions[0].TxLock = TxLockImmediate
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "only supported for sqlite") {
+			t.Errorf("Expected tx_lock to be rejected for postgres, received: %v", err)
+		}
+	})
+
+	t.Run("TestMixedBackendPartitions", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		dsn, stop := startTestPostgres(t)
+		defer stop()
+
+		dir, err := ioutil.TempDir("", "enrollment")
+		if err != nil {
+			t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+		}
+		defer os.RemoveAll(dir)
+
+		lite, err := NewDatabase("enrollment1.db", DSNWithTxLock(filepath.Join(dir, "enrollment1.db"), TxLockImmediate), 65, 77)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pg, err := NewDatabaseWithDialect(PostgresDialect, "enrollment-pg", dsn, 78, 90)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm, err := NewPartitionManager([]*Database{lite, pg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		prev := topology
+		topology = NewTopology(&pm, "")
+		defer func() {
+			topology.Close()
+			topology = prev
+		}()
+
+		if err := createDatabases([]*Database{pg}); err != nil {
+			t.Fatalf("Expected postgres tables to be dropped, received error: %v", err)
+		}
+		if err := createTables(pm.DBs); err != nil {
+			t.Fatalf("Expected to create tables, received error: %v", err)
+		}
+		if _, err := addTerm(testTerm); err != nil {
+			t.Fatalf("Expected to add term, received error: %v", err)
+		}
+		course := addTestCourse(t, Course{"DB101", "Databases 101", 1})
+
+		students, err := addStudents([]Student{{Name: "Ada Lovelace", Mobile: "8885551000"}, {Name: "Rob Pike", Mobile: "8885551001"}})
+		if err != nil {
+			t.Fatalf("Expected students to be added, received error: %v", err)
+		}
+		if students[1].ID == 0 {
+			t.Error("Expected postgres to return the generated student id")
+		}
+
+		for k, want := range []EnrollmentStatus{EnrollmentStatusEnrolled, EnrollmentStatusWaitlisted} {
+			e, err := enrollStudent(students[1-k], testTerm, []Course{course})
+			if err != nil {
+				t.Fatalf("Expected enrollment, received error: %v", err)
+			}
+			if e[0].Status != want {
+				t.Errorf("Expected %s, received: %s", want, e[0].Status)
+			}
+		}
+
+		all, err := getStudents()
+		if err != nil || len(all) != 2 {
+			t.Errorf("Expected students from both backends, received: %+v, error: %v", all, err)
+		}
+
+		// LOWER and LIKE ... ESCAPE in the student searches
+		matches, err := searchStudents("ro", SearchPrefix, 0)
+		if err != nil || len(matches) != 1 || matches[0].Partition != pg.Name {
+			t.Errorf("Expected Rob Pike from postgres, received: %+v, error: %v", matches, err)
+		}
+		matches, err = searchStudents("O", SearchSubstring, 0)
+		if err != nil || len(matches) != 2 {
+			t.Errorf("Expected both students, received: %+v, error: %v", matches, err)
+		}
+
+		// postgres returns NUMERIC sums and averages as text, which must merge with
+		// sqlite's numbers
+		if err := setFinalGrade(students[1], testTerm, course.CourseCode, "A"); err != nil {
+			t.Fatal(err)
+		}
+		summaries, err := getTermEnrollmentSummaries()
+		if err != nil || len(summaries) != 1 || summaries[0].Enrollments != 1 || summaries[0].AverageGradePoints != 4.0 {
+			t.Errorf("Expected one enrollment with 4.0 grade points, received: %+v, error: %v", summaries, err)
+		}
+	})
+}
+
+// HELPER FUNCTIONS //
+
+// startTestPostgres returns the DSN of a PostgreSQL server for a test: the server in
+// ENROLLMENT_POSTGRES_DSN if it is set, or else a throwaway server started in a temp
+// directory. The test is skipped if there is no server to use. The returned func
+// stops the throwaway server and removes its directory.
+func startTestPostgres(t *testing.T) (string, func()) {
+	if dsn := os.Getenv("ENROLLMENT_POSTGRES_DSN"); dsn != "" {
+		return dsn, func() {}
+	}
+
+	bin := os.Getenv("PG_BIN")
+	if bin == "" {
+		if initdb, err := exec.LookPath("initdb"); err == nil {
+			bin = filepath.Dir(initdb)
+		} else if dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin"); len(dirs) > 0 {
+			sort.Strings(dirs)
+			bin = dirs[len(dirs)-1]
+		}
+	}
+	if _, err := os.Stat(filepath.Join(bin, "initdb")); bin == "" || err != nil {
+		t.Skip("no PostgreSQL binaries found: install PostgreSQL, set PG_BIN, or set ENROLLMENT_POSTGRES_DSN")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("PostgreSQL refuses to run as root: run the tests as another user, or set ENROLLMENT_POSTGRES_DSN")
+	}
+
+	dir, err := ioutil.TempDir("", "enrollment-pg")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(filepath.Join(bin, "initdb"), "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "-N").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Expected initdb to succeed, received error: %v\n%s", err, out)
+	}
+
+	// ask the kernel for a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	server := exec.Command(filepath.Join(bin, "postgres"), "-D", data, "-h", "127.0.0.1", "-p", strconv.Itoa(port), "-k", dir, "-F")
+	var logs bytes.Buffer
+	server.Stdout, server.Stderr = &logs, &logs
+	if err := server.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Expected postgres to start, received error: %v", err)
+	}
+	stop := func() {
+		server.Process.Signal(os.Interrupt)
+		server.Wait()
+		os.RemoveAll(dir)
+	}
+
+	dsn := fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for start := time.Now(); db.Ping() != nil; time.Sleep(100 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			stop()
+			t.Fatalf("Expected postgres to accept connections, received logs:\n%s", logs.String())
+		}
+	}
+	return dsn, stop
+}
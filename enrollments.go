@@ -0,0 +1,186 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// EnrollmentRecord is an enrollment together with the student it belongs to.
+type EnrollmentRecord struct {
+	// Partition is the partition the student is stored in. Student ids are only unique
+	// within a partition.
+	Partition  string     `json:"partition"`
+	Student    Student    `json:"student"`
+	Enrollment Enrollment `json:"enrollment"`
+}
+
+// getEnrollmentsBetween lists the enrollments made at or after from and before to,
+// in every course or, if courseCode is not empty, in one course, ordered by enrollment
+// date across all partitions. The course code is normalized as it is when written. limit caps the number returned; zero returns them all.
+// Partitions that are unavailable are skipped; the enrollments from the remaining
+// partitions are returned along with a *PartialResultError.
+func getEnrollmentsBetween(from time.Time, to time.Time, courseCode string, limit int) ([]EnrollmentRecord, error) {
+	records := []EnrollmentRecord{}
+	err := scanEnrollmentsBetween(from, to, courseCode, limit, func(r EnrollmentRecord) bool {
+		records = append(records, r)
+		return true
+	})
+	return records, err
+}
+
+// scanEnrollmentsBetween calls fn for each enrollment made at or after from and before to,
+// in date order, until fn returns false. Each partition reads its enrollments in date
+// order using the enrollment_date_enrolled index, and the sorted streams are merged
+// as they are read, so only one row per partition is held in memory. With a limit,
+// each partition reads at most limit rows, since the first limit rows of the merge can
+// only come from the first limit rows of each partition.
+func scanEnrollmentsBetween(from time.Time, to time.Time, courseCode string, limit int, fn func(EnrollmentRecord) bool) error {
+	if !from.Before(to) {
+		return fmt.Errorf("Unable to list enrollments: %s is not before %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	// a code that is not valid matches no enrollments
+	if courseCode != "" {
+		courseCode, _ = normalizeCourseCode(courseCode)
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := `SELECT e.date_enrolled, e.course_code, e.term_code, e.final_grade, s.id, s.name, s.mobile
+			FROM enrollment AS e
+				JOIN students AS s ON e.student_id = s.id
+			WHERE e.date_enrolled >= ? AND e.date_enrolled < ?`
+	args := []interface{}{from.Unix(), to.Unix()}
+	if courseCode != "" {
+		query += ` AND e.course_code = ?`
+		args = append(args, courseCode)
+	}
+	query += ` ORDER BY e.date_enrolled, e.student_id, e.course_code, e.term_code`
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// open a stream on every partition and seed the heap with its first row
+	streams := &enrollmentStreams{}
+	defer streams.close()
+	var partial *PartialResultError
+	for i, partition := range pm.DBs {
+		rows, err := partition.queryReader(ctx, query, args...)
+		if isPartitionUnavailable(err) {
+			partial = partial.skip(partition, err)
+			continue
+		}
+		if err != nil {
+			return wrapError(partition, "list enrollments", err)
+		}
+
+		s := &enrollmentStream{partition: partition, order: i, rows: rows}
+		streams.all = append(streams.all, s)
+		ok, err := s.next()
+		if err != nil {
+			return wrapError(partition, "list enrollments", err)
+		}
+		if ok {
+			heap.Push(streams, s)
+		}
+	}
+
+	// repeatedly take the earliest row and replace it with the next row of its stream
+	for n := 0; streams.Len() > 0 && (limit <= 0 || n < limit); n++ {
+		s := streams.heads[0]
+		if !fn(s.head) {
+			break
+		}
+
+		ok, err := s.next()
+		if err != nil {
+			return wrapError(s.partition, "list enrollments", err)
+		}
+		if ok {
+			heap.Fix(streams, 0)
+		} else {
+			heap.Pop(streams)
+		}
+	}
+
+	if partial != nil {
+		return partial
+	}
+	return nil
+}
+
+// enrollmentStream reads one partition's enrollments in date order.
+type enrollmentStream struct {
+	partition *Database
+	// order is the partition's position, which breaks ties between partitions.
+	order int
+	rows  *sql.Rows
+	head  EnrollmentRecord
+}
+
+// next reads the stream's next row into head and reports whether there was one.
+func (s *enrollmentStream) next() (bool, error) {
+	if !s.rows.Next() {
+		return false, s.rows.Err()
+	}
+
+	r := EnrollmentRecord{Partition: s.partition.Name, Enrollment: Enrollment{Status: EnrollmentStatusEnrolled}}
+	var enrolled int64
+	var grade sql.NullString
+	err := s.rows.Scan(&enrolled, &r.Enrollment.CourseCode, &r.Enrollment.TermCode, &grade, &r.Student.ID, &r.Student.Name, &r.Student.Mobile)
+	if err != nil {
+		return false, err
+	}
+	r.Enrollment.StudentID = r.Student.ID
+	r.Enrollment.DateEnrolled = time.Unix(enrolled, 0).UTC()
+	r.Enrollment.FinalGrade = grade.String
+	s.head = r
+	return true, nil
+}
+
+// enrollmentStreams is a min-heap of streams ordered by their head rows, in the same
+// order each partition sorts its rows, with ties between partitions broken by
+// partition order.
+type enrollmentStreams struct {
+	heads []*enrollmentStream
+	all   []*enrollmentStream
+}
+
+func (h *enrollmentStreams) Len() int { return len(h.heads) }
+
+func (h *enrollmentStreams) Less(x, y int) bool {
+	a, b := h.heads[x], h.heads[y]
+	ae, be := a.head.Enrollment, b.head.Enrollment
+	switch {
+	case !ae.DateEnrolled.Equal(be.DateEnrolled):
+		return ae.DateEnrolled.Before(be.DateEnrolled)
+	case a.order != b.order:
+		return a.order < b.order
+	}
+	// rows from the same partition keep the order they were read in
+	return false
+}
+
+func (h *enrollmentStreams) Swap(x, y int) { h.heads[x], h.heads[y] = h.heads[y], h.heads[x] }
+
+func (h *enrollmentStreams) Push(v interface{}) { h.heads = append(h.heads, v.(*enrollmentStream)) }
+
+func (h *enrollmentStreams) Pop() interface{} {
+	last := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return last
+}
+
+// close closes every stream that was opened, including those already drained.
+func (h *enrollmentStreams) close() {
+	for _, s := range h.all {
+		s.rows.Close()
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestEnrollmentsBetween
+// Run sub test:  	go test -run TestEnrollmentsBetween/TestMergedInDateOrder
+func TestEnrollmentsBetween(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	addTestCourse(t, Course{"DB101", "Databases 101", 0})
+	addTestCourse(t, Course{"ALGO201", "Algorithms 201", 0})
+	// Ken Thompson is in the first partition and Rob Pike in the second
+	students, err := addStudents([]Student{{Name: "Ken Thompson", Mobile: "8885551112"}, {Name: "Rob Pike", Mobile: "8885551111"}})
+	if err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+
+	pm, release := topology.Acquire()
+	release()
+	day := func(d int) time.Time { return time.Date(2021, time.August, d, 0, 0, 0, 0, time.UTC) }
+	// enrollment dates interleave between the partitions
+	for _, e := range []struct {
+		student int
+		course  string
+		date    time.Time
+	}{
+		{0, "DB101", day(1)},
+		{1, "DB101", day(2)},
+		{0, "ALGO201", day(3)},
+		{1, "ALGO201", day(3)},
+		{1, "ML301", day(5)},
+	} {
+		partition := pm.GetDatabaseByPartitionString(students[e.student].Name)
+		_, err := partition.db.Exec(`INSERT INTO enrollment(student_id, course_code, term_code, date_enrolled) VALUES (?, ?, ?, ?)`,
+			students[e.student].ID, e.course, testTerm.Code, e.date.Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	describe := func(records []EnrollmentRecord) []string {
+		var s []string
+		for _, r := range records {
+			s = append(s, fmt.Sprintf("%s %s %d", strings.Fields(r.Student.Name)[0], r.Enrollment.CourseCode, r.Enrollment.DateEnrolled.Day()))
+		}
+		return s
+	}
+
+	// TESTS //
+	t.Run("TestMergedInDateOrder", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		records, err := getEnrollmentsBetween(day(1), day(5), "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the window excludes its end, and ties go to the first partition
+		want := []string{"Ken DB101 1", "Rob DB101 2", "Ken ALGO201 3", "Rob ALGO201 3"}
+		if fmt.Sprint(describe(records)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, describe(records))
+		}
+		if records[1].Partition != "enrollment2.db" || records[1].Student.ID != students[1].ID {
+			t.Errorf("Expected the record to carry its student and partition, received: %+v", records[1])
+		}
+	})
+
+	t.Run("TestCourseAndLimit", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		records, err := getEnrollmentsBetween(day(1), day(30), "ALGO201", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Ken ALGO201 3", "Rob ALGO201 3"}; fmt.Sprint(describe(records)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, describe(records))
+		}
+
+		records, err = getEnrollmentsBetween(day(2), day(30), "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Rob DB101 2", "Ken ALGO201 3"}; fmt.Sprint(describe(records)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, describe(records))
+		}
+
+		if _, err := getEnrollmentsBetween(day(5), day(5), "", 0); err == nil {
+			t.Error("Expected an empty window to be rejected, received nil")
+		}
+	})
+
+	t.Run("TestUsesDateIndex", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		rows, err := pm.DBs[0].db.Query(`EXPLAIN QUERY PLAN SELECT student_id FROM enrollment
+			WHERE date_enrolled >= ? AND date_enrolled < ? ORDER BY date_enrolled`, day(1).Unix(), day(5).Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		if !strings.Contains(strings.Join(plan, "\n"), "enrollment_date_enrolled") {
+			t.Errorf("Expected the query to use enrollment_date_enrolled, received plan: %v", plan)
+		}
+	})
+
+	t.Run("TestPartialResults", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		second := pm.DBs[1]
+		second.ConfigureCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1})
+		defer second.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		second.withBreaker(func() error { return context.DeadlineExceeded })
+
+		records, err := getEnrollmentsBetween(day(1), day(30), "", 0)
+		var partial *PartialResultError
+		if !errors.As(err, &partial) {
+			t.Fatalf("Expected a *PartialResultError, received: %v", err)
+		}
+		if want := []string{"Ken DB101 1", "Ken ALGO201 3"}; fmt.Sprint(describe(records)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, describe(records))
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
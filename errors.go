@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// The data layer reports failures callers need to tell apart with the sentinel errors
+// below, so callers can test for them with errors.Is instead of matching messages or
+// driver error codes. Errors from the database driver are wrapped in a *PartitionError
+// naming the partition and the operation that failed, and the sentinels a driver
+// error corresponds to are matched through it.
+
+var (
+	// ErrNotFound means a student, enrollment, course offering or partition that a
+	// request named does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate means a write would have stored a value that must be unique, e.g.
+	// enrolled a student in a course twice or given two students the same mobile
+	// number.
+	ErrDuplicate = errors.New("duplicate")
+	// ErrConstraint means a write violated a constraint other than uniqueness, e.g.
+	// offered a course that does not exist.
+	ErrConstraint = errors.New("constraint violated")
+	// ErrPartitionUnavailable means a partition could not serve the request: it was
+	// marked down by the health monitor and has no healthy replica, its circuit
+	// breaker is open, or it timed out or failed.
+	ErrPartitionUnavailable = errors.New("partition unavailable")
+	// ErrNoPartitionForKey means a value has no partition to route it to, because
+	// it is empty or its partition key is outside the partition key space.
+	ErrNoPartitionForKey = errors.New("no partition for key")
+)
+
+// PartitionError is returned when an operation fails on a partition. It wraps the
+// driver's error, and matches ErrNotFound, ErrDuplicate, ErrConstraint or
+// ErrPartitionUnavailable when the driver's error means that.
+type PartitionError struct {
+	Partition string
+	// Op is the operation that failed, e.g. "enroll student". It is empty when the
+	// request was rejected before it reached the partition.
+	Op  string
+	Err error
+}
+
+func (e *PartitionError) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("%s: %v", e.Partition, e.Err)
+	}
+	return fmt.Sprintf("Unable to %s on %s: %v", e.Op, e.Partition, e.Err)
+}
+
+func (e *PartitionError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the driver's error corresponds to target.
+func (e *PartitionError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return errors.Is(e.Err, sql.ErrNoRows)
+	case ErrDuplicate:
+		return isUniqueViolation(e.Err)
+	case ErrConstraint:
+		return isConstraintViolation(e.Err)
+	case ErrPartitionUnavailable:
+		return isPartitionUnavailable(e.Err)
+	}
+	return false
+}
+
+// wrapError wraps err, returned by op on partition, in a *PartitionError if it came
+// from the database driver. Errors of the app's own checks, such as a
+// *ValidationError, already describe what failed and are returned unchanged, as are
+// errors that already name their partition.
+func wrapError(partition *Database, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*PartitionError); ok && e.Op == "" {
+		return &PartitionError{Partition: e.Partition, Op: op, Err: e.Err}
+	}
+	var e *PartitionError
+	if errors.As(err, &e) || !isDriverError(err) {
+		return err
+	}
+	return &PartitionError{Partition: partition.Name, Op: op, Err: err}
+}
+
+// isDriverError reports whether err came from the database driver or database/sql,
+// rather than from the app's own checks.
+func isDriverError(err error) bool {
+	if _, ok := sqliteErrorCode(err); ok {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) ||
+		errors.Is(err, sql.ErrNoRows) || errors.Is(err, sql.ErrTxDone) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.Canceled) || isPartitionUnavailable(err)
+}
+
+// isUniqueViolation reports whether err was caused by a write of a duplicate primary
+// key or unique value.
+func isUniqueViolation(err error) bool {
+	if code, ok := sqliteExtendedErrorCode(err); ok {
+		return code == sqliteConstraintPrimaryKey || code == sqliteConstraintUnique
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// isConstraintViolation reports whether err was caused by a write that violated a
+// constraint other than uniqueness: a foreign key, NOT NULL or CHECK constraint.
+func isConstraintViolation(err error) bool {
+	if isUniqueViolation(err) {
+		return false
+	}
+	if code, ok := sqliteErrorCode(err); ok {
+		return code == sqliteConstraint
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Class() == "23"
+}
+
+// kindError is an error of the app's own checks that matches a sentinel error, e.g.
+// ErrNotFound, without adding the sentinel's text to its message.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+// kindErrorf returns an error with the formatted message that matches kind.
+func kindErrorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *kindError) Error() string {
+	return e.msg
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
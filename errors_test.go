@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestSentinelErrors
+// Run sub test:  	go test -run TestSentinelErrors/TestDuplicate
+func TestSentinelErrors(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	db := addTestCourse(t, Course{"DB101", "Databases 101", 0})
+	ken, err := addStudent(Student{Name: "Ken Thompson", Mobile: "8885551112"})
+	if err != nil {
+		t.Fatalf("Expected student to be added, received error: %v", err)
+	}
+	if _, err := enrollStudent(ken, testTerm, []Course{db}); err != nil {
+		t.Fatal(err)
+	}
+
+	pm, release := topology.Acquire()
+	release()
+
+	// TESTS //
+	t.Run("TestNotFound", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		_, err := getStudent(StudentRef{"enrollment1.db", 99})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown id, received: %v", err)
+		}
+		if err := withdrawStudent(ken, testTerm, Course{CourseCode: "ALGO201"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for a course the student is not in, received: %v", err)
+		}
+		if _, err := updateStudent(Student{ID: 99, Name: "Ken Thompson"}, ken); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for an unknown student, received: %v", err)
+		}
+	})
+
+	t.Run("TestDuplicate", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		_, err := enrollStudent(ken, testTerm, []Course{db})
+		if !errors.Is(err, ErrDuplicate) || errors.Is(err, ErrConstraint) {
+			t.Errorf("Expected only ErrDuplicate, received: %v", err)
+		}
+		if want := "Ken Thompson is already enrolled in DB101 for 2021FA"; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q, received: %v", want, err)
+		}
+
+		_, err = addStudent(Student{Name: "Rob Pike", Mobile: "8885551112"})
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected a reused mobile number to be ErrDuplicate, received: %v", err)
+		}
+
+		_, err = addTerm(testTerm)
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected a term every partition has to be ErrDuplicate, received: %v", err)
+		}
+
+		// a driver error is wrapped with the partition and operation
+		_, err = pm.DBs[0].db.Exec(`INSERT INTO terms(code, name, start_date, end_date) VALUES (?, ?, ?, ?)`,
+			testTerm.Code, testTerm.Name, testTerm.StartDate.Unix(), testTerm.EndDate.Unix())
+		err = wrapError(pm.DBs[0], "add term", err)
+		var partitionErr *PartitionError
+		if !errors.As(err, &partitionErr) || partitionErr.Partition != "enrollment1.db" || partitionErr.Op != "add term" {
+			t.Fatalf("Expected a *PartitionError for the first partition, received: %v", err)
+		}
+		if !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected ErrDuplicate, received: %v", err)
+		}
+	})
+
+	t.Run("TestConstraint", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		_, err := pm.DBs[0].db.Exec(`INSERT INTO students(name, mobile) VALUES (NULL, '')`)
+		err = wrapError(pm.DBs[0], "add student", err)
+		if !errors.Is(err, ErrConstraint) || errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected only ErrConstraint, received: %v", err)
+		}
+	})
+
+	t.Run("TestAppErrorsMatchSentinels", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		// a trigger makes inserts of IGN101 write nothing without failing
+		for _, p := range pm.DBs {
+			_, err := p.db.Exec(`CREATE TRIGGER ignore_ign101 BEFORE INSERT ON courses WHEN NEW.code = 'IGN101'
+				BEGIN SELECT RAISE(IGNORE); END`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer func() {
+			for _, p := range pm.DBs {
+				p.db.Exec(`DROP TRIGGER ignore_ign101`)
+			}
+		}()
+
+		tests := []struct {
+			name string
+			run  func() error
+			kind error
+		}{
+			{"rename across partitions", func() error {
+				_, err := updateStudent(ken, Student{Name: "Zed Thompson", Mobile: ken.Mobile})
+				return err
+			}, ErrConstraint},
+			{"course insert ignored", func() error {
+				_, err := addCourse(Course{"IGN101", "Ignored 101", 0})
+				return err
+			}, ErrDuplicate},
+			{"enrollment insert ignored", func() error {
+				first := pm.DBs[0]
+				stmt, err := first.db.Prepare(`INSERT OR IGNORE INTO enrollment(student_id, course_code, term_code, date_enrolled, final_grade)
+					VALUES (?, ?, ?, ?, ?)`)
+				if err != nil {
+					return err
+				}
+				defer stmt.Close()
+				return first.WithTx(context.Background(), func(tx *sql.Tx) error {
+					return execEnrollStudentSql(context.Background(), tx, stmt, ken.ID, db.CourseCode, testTerm.Code, time.Now().Unix(), nil)
+				})
+			}, ErrDuplicate},
+			{"mobile used by two students", func() error {
+				_, err := pm.DBs[1].db.Exec(`INSERT INTO students(name, mobile) VALUES ('Rob Pike', ?)`, ken.Mobile)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					pm.DBs[1].db.Exec(`DELETE FROM students WHERE name = 'Rob Pike'`)
+					rebuildMobileIndex()
+				}()
+				_, err = rebuildMobileIndex()
+				return err
+			}, ErrDuplicate},
+		}
+		for _, tt := range tests {
+			if err := tt.run(); !errors.Is(err, tt.kind) {
+				t.Errorf("Expected %s to match %v, received: %v", tt.name, tt.kind, err)
+			}
+		}
+	})
+
+	t.Run("TestNoPartitionForKey", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		for _, name := range []string{"", "  ", "1st Student"} {
+			if _, err := getStudentsByName(name); !errors.Is(err, ErrNoPartitionForKey) {
+				t.Errorf("Expected ErrNoPartitionForKey for %q, received: %v", name, err)
+			}
+		}
+		if _, err := getTranscript(Student{ID: 1, Name: "_"}); !errors.Is(err, ErrNoPartitionForKey) {
+			t.Errorf("Expected ErrNoPartitionForKey, received: %v", err)
+		}
+	})
+
+	t.Run("TestPartitionUnavailable", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		first := pm.DBs[0]
+		first.ConfigureCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1})
+		defer first.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		first.withBreaker(func() error { return context.DeadlineExceeded })
+
+		_, err := getStudent(studentRefOf(ken))
+		if !errors.Is(err, ErrPartitionUnavailable) || !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Expected ErrPartitionUnavailable, received: %v", err)
+		}
+		var partitionErr *PartitionError
+		if !errors.As(err, &partitionErr) || partitionErr.Op != "get student" {
+			t.Errorf("Expected the operation to be named, received: %v", err)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// HealthCheckConfig controls how often partitions are checked and how many
+// consecutive results it takes to change a partition's state. Requiring several
+// results in a row keeps a single slow check from flapping a partition down and up.
+type HealthCheckConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// FailureThreshold is the number of consecutive failed checks that marks an
+	// up partition down.
+	FailureThreshold int
+	// RecoveryThreshold is the number of consecutive successful checks that marks
+	// a down partition up again.
+	RecoveryThreshold int
+}
+
+// DefaultHealthCheckConfig is used when the app starts and when the topology is reloaded.
+var DefaultHealthCheckConfig = HealthCheckConfig{
+	Interval:          2 * time.Second,
+	Timeout:           500 * time.Millisecond,
+	FailureThreshold:  3,
+	RecoveryThreshold: 2,
+}
+
+// HealthStatus is a point-in-time view of a partition's or replica's health.
+type HealthStatus struct {
+	Name        string
+	Up          bool
+	LastChecked time.Time
+	LastError   string
+	// Circuit is the state of the partition's circuit breaker; it is not set for replicas.
+	Circuit  CircuitState
+	Replicas []HealthStatus
+}
+
+// healthState tracks the up/down state of one connection pool. Pools start out up
+// so requests are not rejected before the first check has run.
+type healthState struct {
+	mu                   sync.RWMutex
+	down                 bool
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	lastChecked          time.Time
+	lastErr              error
+}
+
+// Available reports whether the pool is currently considered up.
+func (h *healthState) Available() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return !h.down
+}
+
+// record applies the result of a check and reports whether the state changed.
+func (h *healthState) record(err error, cfg HealthCheckConfig) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastChecked = time.Now()
+	h.lastErr = err
+	if err != nil {
+		h.consecutiveSuccesses = 0
+		h.consecutiveFailures++
+		if !h.down && h.consecutiveFailures >= cfg.FailureThreshold {
+			h.down = true
+			return true
+		}
+		return false
+	}
+
+	h.consecutiveFailures = 0
+	h.consecutiveSuccesses++
+	if h.down && h.consecutiveSuccesses >= cfg.RecoveryThreshold {
+		h.down = false
+		return true
+	}
+	return false
+}
+
+func (h *healthState) status(name string) HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	s := HealthStatus{Name: name, Up: !h.down, LastChecked: h.lastChecked}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+// checkConnection runs the dialect's health check query against the pool within
+// the check timeout.
+func checkConnection(db *sql.DB, dialect Dialect, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var n int
+	return db.QueryRowContext(ctx, dialect.healthCheckQuery()).Scan(&n)
+}
+
+// checkHealth checks the partition and its replicas once and logs state changes. The
+// primary is checked through its read pool if it has one, since the writer pool is
+// then limited to one connection and a long write transaction would hold it past the
+// check timeout.
+func (i *Database) checkHealth(cfg HealthCheckConfig) {
+	if i.health.record(checkConnection(i.primaryReader(), i.Dialect, cfg.Timeout), cfg) {
+		if i.health.Available() {
+			log.Printf("Partition %s is up", i.Name)
+		} else {
+			log.Printf("Partition %s is down: %v", i.Name, i.health.status(i.Name).LastError)
+		}
+	}
+
+	for _, r := range i.replicas {
+		// replicas are only supported for sqlite partitions
+		if r.health.record(checkConnection(r.db, SQLiteDialect, cfg.Timeout), cfg) {
+			log.Printf("Replica %s of %s up: %t", r.Name, i.Name, r.health.Available())
+		}
+	}
+}
+
+// Available reports whether the partition's primary is considered up.
+func (i *Database) Available() bool {
+	return i.health.Available()
+}
+
+// unavailableError wraps ErrPartitionUnavailable with the partition name.
+func (i *Database) unavailableError() error {
+	return &PartitionError{Partition: i.Name, Err: ErrPartitionUnavailable}
+}
+
+// StartHealthChecks checks every partition on cfg.Interval in the background until
+// CloseConnections is called.
+func (pm *PartitionManager) StartHealthChecks(cfg HealthCheckConfig) {
+	if pm.stopHealthChecks != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	pm.stopHealthChecks = stop
+	pm.healthChecksDone = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for _, db := range pm.DBs {
+					db.checkHealth(cfg)
+				}
+			}
+		}
+	}()
+}
+
+// HealthStatus returns the current health of every partition and its replicas.
+func (pm *PartitionManager) HealthStatus() []HealthStatus {
+	statuses := make([]HealthStatus, len(pm.DBs))
+	for i, db := range pm.DBs {
+		statuses[i] = db.health.status(db.Name)
+		statuses[i].Circuit = db.CircuitState()
+		for _, r := range db.replicas {
+			statuses[i].Replicas = append(statuses[i].Replicas, r.health.status(r.Name))
+		}
+	}
+	return statuses
+}
+
+// stopHealthMonitor stops the background health checks, if running.
+func (pm *PartitionManager) stopHealthMonitor() {
+	if pm.stopHealthChecks == nil {
+		return
+	}
+	close(pm.stopHealthChecks)
+	<-pm.healthChecksDone
+	pm.stopHealthChecks = nil
+}
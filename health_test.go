@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestHealth
+// Run sub test:  	go test -run TestHealth/TestHysteresis
+func TestHealth(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	dir, err := ioutil.TempDir("", "enrollment")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDatabase("health.db", filepath.Join(dir, "health.db"), 65, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewPartitionManager([]*Database{db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := HealthCheckConfig{Interval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond, FailureThreshold: 2, RecoveryThreshold: 2}
+	checkErr := errors.New("disk unavailable")
+
+	// TESTS //
+	t.Run("TestHysteresis", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		h := &healthState{}
+
+		if h.record(checkErr, cfg) || !h.Available() {
+			t.Fatal("Expected a single failed check to leave the pool up")
+		}
+		if !h.record(checkErr, cfg) || h.Available() {
+			t.Fatal("Expected consecutive failed checks to mark the pool down")
+		}
+		if h.record(nil, cfg) || h.Available() {
+			t.Fatal("Expected a single successful check to leave the pool down")
+		}
+		if h.record(checkErr, cfg) || h.Available() {
+			t.Fatal("Expected a failed check to reset the recovery count")
+		}
+		h.record(nil, cfg)
+		if !h.record(nil, cfg) || !h.Available() {
+			t.Fatal("Expected consecutive successful checks to mark the pool up")
+		}
+	})
+
+	t.Run("TestWithTxFailsFastWhenDown", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.health.record(checkErr, cfg)
+		db.health.record(checkErr, cfg)
+		defer func() {
+			db.health.record(nil, cfg)
+			db.health.record(nil, cfg)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		called := false
+		err := db.WithTx(ctx, func(tx *sql.Tx) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, ErrPartitionUnavailable) {
+			t.Errorf("Expected ErrPartitionUnavailable, received: %v", err)
+		}
+		if called {
+			t.Error("Expected the transaction not to run on a down partition")
+		}
+	})
+
+	t.Run("TestHealthStatus", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		pm.StartHealthChecks(cfg)
+		defer pm.stopHealthMonitor()
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if !pm.HealthStatus()[0].LastChecked.IsZero() {
+				break
+			}
+			time.Sleep(cfg.Interval)
+		}
+
+		status := pm.HealthStatus()
+		if len(status) != 1 || status[0].Name != "health.db" {
+			t.Fatalf("Expected status for health.db, received: %+v", status)
+		}
+		if !status[0].Up || status[0].LastChecked.IsZero() || status[0].LastError != "" {
+			t.Errorf("Expected health.db to be checked and up, received: %+v", status[0])
+		}
+	})
+
+	t.Run("TestMonitorMarksClosedPartitionDown", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		down, err := NewDatabase("down.db", filepath.Join(dir, "down.db"), 65, 90)
+		if err != nil {
+			t.Fatal(err)
+		}
+		down.db.Close()
+
+		for k := 0; k < cfg.FailureThreshold; k++ {
+			down.checkHealth(cfg)
+		}
+		if down.Available() {
+			t.Error("Expected a partition that cannot be queried to be marked down")
+		}
+	})
+
+	t.Run("TestLongWriteDoesNotMarkSplitPartitionDown", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		splitCfg := PartitionConfig{Partitions: []PartitionDefinition{{
+			Name:        "split.db",
+			DSN:         filepath.Join(dir, "split.db"),
+			TxLock:      TxLockImmediate,
+			KeyRange:    &KeyRange{"A", "Z"},
+			JournalMode: "wal",
+			BusyTimeout: Duration(time.Second),
+			Pool:        PoolConfig{MaxOpenConns: 4, SplitReadWrite: true},
+		}}}
+		dbs, err := splitCfg.OpenDatabases()
+		if err != nil {
+			t.Fatalf("Expected database to open, received error: %v", err)
+		}
+		split := dbs[0]
+		defer split.Close()
+
+		// hold the only writer connection across several health ticks
+		tx, err := split.db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`CREATE TABLE courses (code TEXT PRIMARY KEY)`); err != nil {
+			t.Fatal(err)
+		}
+
+		for k := 0; k < cfg.FailureThreshold+2; k++ {
+			split.checkHealth(cfg)
+			time.Sleep(cfg.Interval)
+		}
+		if !split.Available() {
+			t.Errorf("Expected the partition to stay up during a write, received: %+v", split.health.status(split.Name))
+		}
+	})
+
+	// TEST TEAR DOWN //
+	pm.CloseConnections()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Students are partitioned by name, so a unique index on students.mobile in one
+// partition can't stop a student in another partition from using the same mobile
+// number, and finding a student by mobile number would mean reading every partition.
+// Instead, the student_mobiles table in the index partition (see GetIndexDatabase)
+// maps each mobile number to the partition and id of the student who uses it, and its
+// primary key keeps mobile numbers unique. Like a seat in the seat counter, a mobile
+// number is claimed in the index before the student is written to their partition,
+// and released again if that write fails. Students written directly to a partition,
+// e.g. from the SQL shell, are not indexed until rebuildMobileIndex runs.
+
+// DuplicateMobileError is returned when a student is given a mobile number that
+// another student already uses. It matches ErrDuplicate.
+type DuplicateMobileError struct {
+	Mobile string
+	// Existing is the student who uses the number. Its id is zero if that student is
+	// still being added.
+	Existing StudentRef
+}
+
+func (e *DuplicateMobileError) Error() string {
+	return fmt.Sprintf("Unable to save student, mobile %s is already used by student %s", e.Mobile, e.Existing)
+}
+
+func (e *DuplicateMobileError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+// mobileClaim is a mobile number claimed in the index for a student.
+type mobileClaim struct {
+	mobile string
+	ref    StudentRef
+}
+
+// claimMobiles adds every claim to the index in one transaction, or none of them if a
+// number is already used, in which case a *DuplicateMobileError is returned. A claim
+// by the student who already holds the number succeeds, so a retried write can claim
+// again.
+func claimMobiles(ctx context.Context, pm *PartitionManager, claims []mobileClaim) error {
+	if len(claims) == 0 {
+		return nil
+	}
+
+	index := pm.GetIndexDatabase()
+	err := index.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, c := range claims {
+			var existing StudentRef
+			err := tx.QueryRowContext(ctx, index.rebind(`SELECT partition_name, student_id FROM student_mobiles WHERE mobile = ?`),
+				c.mobile).Scan(&existing.Partition, &existing.ID)
+			if err == nil {
+				if existing == c.ref && c.ref.ID != 0 {
+					continue
+				}
+				return &DuplicateMobileError{Mobile: c.mobile, Existing: existing}
+			}
+			if err != sql.ErrNoRows {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, index.rebind(`INSERT INTO student_mobiles(mobile, partition_name, student_id) VALUES (?, ?, ?)`),
+				c.mobile, c.ref.Partition, c.ref.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(index, "claim mobile numbers", err)
+}
+
+// confirmMobiles records the ids of students whose numbers were claimed before they
+// were written and had their ids generated.
+func confirmMobiles(ctx context.Context, pm *PartitionManager, claims []mobileClaim) error {
+	if len(claims) == 0 {
+		return nil
+	}
+
+	index := pm.GetIndexDatabase()
+	err := index.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, c := range claims {
+			_, err := tx.ExecContext(ctx, index.rebind(`UPDATE student_mobiles SET student_id = ? WHERE mobile = ? AND partition_name = ?`),
+				c.ref.ID, c.mobile, c.ref.Partition)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError(index, "confirm mobile numbers", err)
+}
+
+// releaseMobiles removes claims from the index: those of a write that failed, or the
+// numbers of students who were deleted or changed number. It uses its own context so
+// the claims are released even when the caller's context has already expired;
+// failures are logged, since the student's write has already succeeded or failed. A
+// claim that could not be released keeps the number from being reused until the
+// index is rebuilt.
+func releaseMobiles(pm *PartitionManager, claims []mobileClaim) {
+	if len(claims) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	index := pm.GetIndexDatabase()
+	err := index.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, c := range claims {
+			_, err := tx.ExecContext(ctx, index.rebind(`DELETE FROM student_mobiles WHERE mobile = ? AND partition_name = ?`),
+				c.mobile, c.ref.Partition)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Unable to release mobile numbers from the index: %v", err)
+	}
+}
+
+// lookupMobile finds the partition of the student who uses a mobile number in the
+// index, and reports whether the number is used.
+func lookupMobile(ctx context.Context, pm *PartitionManager, mobile string) (StudentRef, bool, error) {
+	index := pm.GetIndexDatabase()
+
+	var ref StudentRef
+	err := index.withBreaker(func() error {
+		return index.primaryReader().QueryRowContext(ctx, index.rebind(`SELECT partition_name, student_id FROM student_mobiles WHERE mobile = ?`),
+			mobile).Scan(&ref.Partition, &ref.ID)
+	})
+	if err == sql.ErrNoRows {
+		return StudentRef{}, false, nil
+	}
+	if err != nil {
+		return StudentRef{}, false, wrapError(index, "look up mobile number", err)
+	}
+	return ref, true, nil
+}
+
+// rebuildMobileIndex replaces the index with the mobile numbers of every student in
+// every partition and returns the number of mobile numbers indexed. It fails without
+// changing the index if a partition can't be read. A number used by more than one
+// student is indexed for the first of them, and the others are listed in the error.
+func rebuildMobileIndex() (int, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	var claims []mobileClaim
+	var duplicates []string
+	owners := make(map[string]StudentRef)
+	for _, partition := range pm.DBs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		students, err := execGetStudentsSql(ctx, partition, `SELECT id, name, mobile FROM students WHERE mobile <> '' ORDER BY id`)
+		if err != nil {
+			return 0, wrapError(partition, "rebuild mobile index", err)
+		}
+		for _, s := range students {
+			ref := StudentRef{Partition: partition.Name, ID: s.ID}
+			if owner, ok := owners[s.Mobile]; ok {
+				duplicates = append(duplicates, fmt.Sprintf("%s (%s and %s)", s.Mobile, owner, ref))
+				continue
+			}
+			owners[s.Mobile] = ref
+			claims = append(claims, mobileClaim{mobile: s.Mobile, ref: ref})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	index := pm.GetIndexDatabase()
+	err := index.WithTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM student_mobiles`); err != nil {
+			return err
+		}
+		for _, c := range claims {
+			_, err := tx.ExecContext(ctx, index.rebind(`INSERT INTO student_mobiles(mobile, partition_name, student_id) VALUES (?, ?, ?)`),
+				c.mobile, c.ref.Partition, c.ref.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, wrapError(index, "rebuild mobile index", err)
+	}
+
+	if len(duplicates) > 0 {
+		return len(claims), kindErrorf(ErrDuplicate, "Unable to index mobile numbers used by more than one student: %s", strings.Join(duplicates, ", "))
+	}
+	return len(claims), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestMobileIndex
+// Run sub test:  	go test -run TestMobileIndex/TestDuplicateAcrossPartitions
+func TestMobileIndex(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	// Ken Thompson is in the first partition and Rob Pike in the second
+	ken, err := addStudent(Student{Name: "Ken Thompson", Mobile: "8885551112"})
+	if err != nil {
+		t.Fatalf("Expected student to be added, received error: %v", err)
+	}
+
+	pm, release := topology.Acquire()
+	release()
+
+	find := func(mobile string) []StudentMatch {
+		matches, err := searchStudentsByMobile(mobile)
+		if err != nil {
+			t.Fatalf("Expected to search by mobile %s, received error: %v", mobile, err)
+		}
+		return matches
+	}
+
+	// TESTS //
+	t.Run("TestDuplicateAcrossPartitions", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		_, err := addStudents([]Student{{Name: "Ian Taylor", Mobile: "8885551115"}, {Name: "Rob Pike", Mobile: "8885551112"}})
+		var duplicate *DuplicateMobileError
+		if !errors.As(err, &duplicate) || duplicate.Existing != (StudentRef{"enrollment1.db", ken.ID}) {
+			t.Fatalf("Expected a *DuplicateMobileError naming Ken Thompson, received: %v", err)
+		}
+		// no student in the batch is added
+		if matches := find("8885551115"); len(matches) != 0 {
+			t.Errorf("Expected Ian Taylor not to be added, received: %+v", matches)
+		}
+
+		_, err = addStudents([]Student{{Name: "Ian Taylor", Mobile: "8885551115"}, {Name: "Rob Pike", Mobile: "8885551115"}})
+		if err == nil {
+			t.Error("Expected a number given to two students in a batch to be rejected, received nil")
+		}
+	})
+
+	t.Run("TestLookupReadsOnePartition", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		second := pm.DBs[1]
+		second.ConfigureCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1})
+		defer second.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		second.withBreaker(func() error { return context.DeadlineExceeded })
+
+		matches := find("8885551112")
+		if len(matches) != 1 || matches[0].Ref() != (StudentRef{"enrollment1.db", ken.ID}) {
+			t.Errorf("Expected Ken Thompson while the second partition is down, received: %+v", matches)
+		}
+
+		// a failed write releases the numbers it claimed
+		if _, err := addStudent(Student{Name: "Rob Pike", Mobile: "8885551111"}); err == nil {
+			t.Fatal("Expected the student not to be added while their partition is down, received nil")
+		}
+		second.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		if _, err := addStudent(Student{Name: "Rob Pike", Mobile: "8885551111"}); err != nil {
+			t.Errorf("Expected the released number to be free, received error: %v", err)
+		}
+	})
+
+	t.Run("TestUpdateAndDelete", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if _, err := updateStudent(ken, Student{Name: ken.Name, Mobile: "8885551111"}); err == nil {
+			t.Error("Expected Rob Pike's number to be rejected, received nil")
+		}
+
+		updated, err := updateStudent(ken, Student{Name: ken.Name, Mobile: "8885550000"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(find("8885551112")) != 0 || len(find("8885550000")) != 1 {
+			t.Errorf("Expected the index to follow the new number, received: %+v, %+v", find("8885551112"), find("8885550000"))
+		}
+
+		if err := deleteStudent(updated); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := addStudent(Student{Name: "Russ Cox", Mobile: "8885550000"}); err != nil {
+			t.Errorf("Expected the deleted student's number to be free, received error: %v", err)
+		}
+	})
+
+	t.Run("TestRebuild", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		// students written directly to a partition are not indexed
+		for _, s := range []Student{{Name: "Robert Griesemer", Mobile: "+18885551113"}, {Name: "Rob Pike", Mobile: "+18885551113"}} {
+			if _, err := pm.DBs[1].db.Exec(`INSERT INTO students(name, mobile) VALUES (?, ?)`, s.Name, s.Mobile); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(find("8885551113")) != 0 {
+			t.Fatal("Expected the number not to be indexed yet")
+		}
+
+		n, err := rebuildMobileIndex()
+		if err == nil {
+			t.Error("Expected the number used by two students to be reported, received nil")
+		}
+		if n != 3 {
+			t.Errorf("Expected 3 mobile numbers to be indexed, received: %d", n)
+		}
+		if matches := find("8885551113"); len(matches) != 2 {
+			t.Errorf("Expected both students with the number, received: %+v", matches)
+		}
+		if matches := find("8885551111"); len(matches) != 1 || matches[0].Student.Name != "Rob Pike" {
+			t.Errorf("Expected Rob Pike, received: %+v", matches)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -5,12 +5,14 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // PLEASE NOTE that I would absolutely not organize in this way an application
@@ -20,38 +22,85 @@ import (
 // opted to keep it really simple.
 
 // global variables (also, I don't generally use globals in production applications)
-var pm PartitionManager
+var topology *Topology
 
 // to build app: make
 // to start app and build sqlite db: ./enrollment build_db=true
 // to start app and use existing db: ./enrollment
+// to start app with a different partition layout: ./enrollment -config=partitions.json
+// to keep app running and reload the partition layout on change/SIGHUP: ./enrollment -watch_config=true
+// to run an administration command: ./enrollment -output=json student list (see ./enrollment help)
 func main() {
 	var (
-		buildDB = flag.Bool("build_db", false, "Set to true to build the sqlite databases and populate them with test data")
+		buildDB     = flag.Bool("build_db", false, "Set to true to build the sqlite databases and populate them with test data")
+		configPath  = flag.String("config", "partitions.json", "Path to the partition config file")
+		watchConfig = flag.Bool("watch_config", false, "Set to true to keep running and reload the partition config when it changes or on SIGHUP")
+		output      = flag.String("output", "table", "Output format of commands: table or json")
+		verbose     = flag.Bool("verbose", false, "Set to true to log to stderr while running a command")
 	)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), cliUsage+"\nFlags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	// commands write their output to stdout and errors to stderr; the log is noise there
+	if flag.NArg() > 0 && !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
 	log.Println("Define db partitions...")
-	dbs, err := createDBPartitions()
+	dbs, err := createDBPartitions(*configPath)
 	if err != nil {
-		log.Fatal(err.Error())
+		fatal(err)
 	}
 
 	log.Println("Building partition manager...")
-	pm = NewPartitionManager(dbs)
-	defer pm.CloseConnections()
+	pm, err := NewPartitionManager(dbs)
+	if err != nil {
+		fatal(err)
+	}
+	log.Printf("Partition routing table:\n%s", pm.Describe())
+	pm.StartHealthChecks(DefaultHealthCheckConfig)
+	topology = NewTopology(&pm, *configPath)
+	defer topology.Close()
 
 	log.Printf("Build sqlite databases? %t", *buildDB)
 	if *buildDB {
 		buildDBAndPopulate()
 	}
 
+	if flag.NArg() > 0 {
+		code := runCommand(flag.Args(), *output, os.Stdin, os.Stdout, os.Stderr)
+		// os.Exit skips deferred calls
+		topology.Close()
+		os.Exit(code)
+	}
+
 	showGetCoursesOutput()
 	showGetStudentsInCourseOutput()
 	showGetCoursesForStudentsOutput()
+	showPoolStats()
+
+	if *watchConfig {
+		ctx, cancel := context.WithCancel(context.Background())
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-stop
+			cancel()
+		}()
+
+		log.Printf("Watching %s for partition topology changes (send SIGHUP to force a reload)...", *configPath)
+		topology.Watch(ctx, 2*time.Second)
+		log.Println("Shutting down...")
+	}
 }
 
 func buildDBAndPopulate() {
+	pm, release := topology.Acquire()
+	defer release()
+
 	err := createDatabases(pm.DBs)
 	if err != nil {
 		log.Fatal(err.Error())
@@ -65,13 +114,19 @@ func buildDBAndPopulate() {
 		log.Fatal(err.Error())
 	}
 	addSampleCourses()
-	addSampleStudents()
-	addSampleEnrollments()
+	addSampleTerms()
+	students := addSampleStudents()
+	addSampleEnrollments(students)
 }
 
 func showGetCoursesOutput() {
 	log.Println("*** Output from getCourses(): ***")
-	c, err := getCourses("Ken Thompson")
+	m, err := findStudentByName("Ken Thompson")
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		return
+	}
+	c, err := getCourses(m.Ref())
 	if err != nil {
 		log.Printf("Error: %v\n", err)
 	}
@@ -93,8 +148,8 @@ func showGetStudentsInCourseOutput() {
 
 func showGetCoursesForStudentsOutput() {
 	log.Println("*** Output from getetCoursesForStudents(): ***")
-	s1 := Student{1, "Ken Thompson", "8885551112"}
-	s2 := Student{1, "Rob Pike", "Rob Pike"}
+	s1 := Student{1, "Ken Thompson", "+18885551112"}
+	s2 := Student{1, "Rob Pike", "+18885551111"}
 	s := []Student{s1, s2}
 	res, err := getCoursesForStudents(s)
 	if err != nil {
@@ -105,70 +160,113 @@ func showGetCoursesForStudentsOutput() {
 	}
 }
 
-func createDBPartitions() ([]*Database, error) {
-	db1, err := NewDatabase("enrollment1.db", "./enrollment1.db", 65, 77)
+func showPoolStats() {
+	log.Println("*** Connection pool stats: ***")
+	pm, release := topology.Acquire()
+	defer release()
+
+	for _, s := range pm.Stats() {
+		log.Printf("%s writer: open=%d in_use=%d idle=%d wait_count=%d wait=%s",
+			s.Name, s.Writer.OpenConnections, s.Writer.InUse, s.Writer.Idle, s.Writer.WaitCount, s.Writer.WaitDuration)
+		if s.Reader != nil {
+			log.Printf("%s reader: open=%d in_use=%d idle=%d wait_count=%d wait=%s",
+				s.Name, s.Reader.OpenConnections, s.Reader.InUse, s.Reader.Idle, s.Reader.WaitCount, s.Reader.WaitDuration)
+		}
+	}
+}
+
+// createDBPartitions opens the database partitions defined in the config file.
+func createDBPartitions(configPath string) ([]*Database, error) {
+	cfg, err := LoadPartitionConfig(configPath)
 	if err != nil {
 		return nil, err
 	}
 
-	db2, err := NewDatabase("enrollment2.db", "./enrollment2.db", 78, 90)
+	return cfg.OpenDatabases()
+}
+
+// AddCourse inserts a new course into every database partition and returns the created course.
+// The course is normalized first, see normalizeCourse. An add that failed part way
+// through can be retried, see addReplicated.
+func addCourse(course Course) (Course, error) {
+	course, err := normalizeCourse(course)
 	if err != nil {
-		return nil, err
+		return Course{}, err
 	}
 
-	dbs := make([]*Database, 2)
-	dbs[0] = db1
-	dbs[1] = db2
+	pm, release := topology.Acquire()
+	defer release()
 
-	return dbs, nil
+	// store unlimited courses with a NULL capacity
+	capacity := sql.NullInt64{Int64: int64(course.Capacity), Valid: course.Capacity > 0}
+	// add course info to each db
+	err = addReplicated(pm, "add course", replicatedRow{
+		name:    course.CourseCode,
+		table:   "courses",
+		columns: []string{"code", "name", "capacity"},
+		key:     []string{"code"},
+		values:  []interface{}{course.CourseCode, course.Name, capacity},
+		same: func(ctx context.Context, partition *Database, tx *sql.Tx) (bool, error) {
+			var name string
+			var existing sql.NullInt64
+			err := tx.QueryRowContext(ctx, partition.rebind(`SELECT name, capacity FROM courses WHERE code = ?`),
+				course.CourseCode).Scan(&name, &existing)
+			// an insert that writes nothing without a conflict was ignored
+			if err == sql.ErrNoRows {
+				return false, nil
+			}
+			return name == course.Name && existing == capacity, err
+		},
+	})
+	if err != nil {
+		return Course{}, err
+	}
+	return course, nil
 }
 
-// AddCourse inserts a new course into the database.
-func addCourse(course Course) error {
-	sql := `INSERT INTO courses(code, name) VALUES (?, ?)`
-	// add course info to each db
-	for i := range pm.DBs {
-		s, err := pm.DBs[i].db.Prepare(sql)
-		if err != nil {
-			return err
-		}
-		defer s.Close()
+// getAllCourses fetches every course ordered by code. Courses are replicated, so
+// they are read from the first partition.
+func getAllCourses() ([]Course, error) {
+	pm, release := topology.Acquire()
+	defer release()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	query := `SELECT code, name, capacity
+			FROM courses
+			ORDER BY code`
 
-		res, err := s.ExecContext(ctx, course.CourseCode, course.Name)
-		if err != nil {
-			return err
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		cnt, err := res.RowsAffected()
-		if err != nil || cnt == 0 {
-			return fmt.Errorf("Unable to add course: %s", course.Name)
-		}
-	}
-	return nil
+	return execGetCoursesSql(ctx, pm.DBs[0], query)
 }
 
-// getCourses fetches courses a student is taking.
-func getCourses(studentName string) ([]Course, error) {
-	partition := pm.GetDatabaseByPartitionString(studentName)
-	sql := `SELECT c.code, c.name 
+// getCourses fetches courses a student is taking. The student is identified by ref
+// rather than by name, since students can share a name; use findStudentByName to
+// look the ref up.
+func getCourses(ref StudentRef) ([]Course, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition := pm.GetDatabaseByName(ref.Partition)
+	if partition == nil {
+		return nil, kindErrorf(ErrNotFound, "Unable to get courses for student %s: no partition named %s", ref, ref.Partition)
+	}
+	sql := `SELECT c.code, c.name, c.capacity
 			FROM enrollment AS e
 				JOIN courses AS c ON e.course_code = c.code 
-				JOIN students AS s ON e.student_id = s.id
-			WHERE s.name = ?`
+			WHERE e.student_id = ?`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return execGetCoursesSql(ctx, partition, sql, studentName)
+	courses, err := execGetCoursesSql(ctx, partition, sql, ref.ID)
+	return courses, wrapError(partition, "get courses", err)
 }
 
 // execGetCoursesSql helper function that accepts a context to limit query run time, a pointer to the correct
 // database partition, a query, and arguments that will be safely merged into the query to avoid sql injection.
 func execGetCoursesSql(ctx context.Context, partition *Database, query string, args ...interface{}) ([]Course, error) {
-	rows, err := partition.db.QueryContext(ctx, query, args...)
+	rows, err := partition.queryReader(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -177,10 +275,12 @@ func execGetCoursesSql(ctx context.Context, partition *Database, query string, a
 	var courses []Course
 	for rows.Next() {
 		c := Course{}
-		err := rows.Scan(&c.CourseCode, &c.Name)
+		var capacity sql.NullInt64
+		err := rows.Scan(&c.CourseCode, &c.Name, &capacity)
 		if err != nil {
 			return nil, err
 		}
+		c.Capacity = int(capacity.Int64)
 		courses = append(courses, c)
 	}
 	err = rows.Err()
@@ -205,73 +305,277 @@ func getStudentsInCourse(courseCode string) ([]Student, error) {
 // merged into the query to avoid sql injection.
 // Also, if we needed ultra-high performance, this could be rewritten to utilize go routines to run the
 // queries to each database partition concurrently.
+// Partitions that are unavailable are skipped; the students from the remaining partitions
+// are returned along with a *PartialResultError.
 func execGetStudentsInCourseSql(query string, args ...interface{}) ([]Student, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
 	var students []Student
+	var partial *PartialResultError
 	for i := range pm.DBs {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		rows, err := pm.DBs[i].db.QueryContext(ctx, query, args...)
+		s, err := execGetStudentsSql(ctx, pm.DBs[i], query, args...)
+		if isPartitionUnavailable(err) {
+			partial = partial.skip(pm.DBs[i], err)
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return nil, wrapError(pm.DBs[i], "get students", err)
 		}
-		defer rows.Close()
+		students = append(students, s...)
+	}
 
-		for rows.Next() {
-			s := Student{}
-			err := rows.Scan(&s.ID, &s.Name, &s.Mobile)
-			if err != nil {
-				return nil, err
-			}
-			students = append(students, s)
-		}
-		err = rows.Err()
+	if partial != nil {
+		return students, partial
+	}
+	return students, nil
+}
+
+// execGetStudentsSql helper function that runs a query returning student rows against one partition.
+func execGetStudentsSql(ctx context.Context, partition *Database, query string, args ...interface{}) ([]Student, error) {
+	rows, err := partition.queryReader(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var students []Student
+	for rows.Next() {
+		s := Student{}
+		err := rows.Scan(&s.ID, &s.Name, &s.Mobile)
 		if err != nil {
 			return nil, err
 		}
+		students = append(students, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
 	}
 
 	return students, nil
 }
 
-// enrollStudent enrolls a student into one or more courses.
-func enrollStudent(student Student, courses []Course) error {
-	sql := `INSERT INTO enrollment VALUES (?, ?, ?, ?)`
-	partition := pm.GetDatabaseByPartitionString(student.Name)
+// enrollStudent enrolls a student into one or more courses offered in a term and
+// returns the created enrollments in the same order as the provided courses. Courses
+// that are at capacity put the student on the waitlist instead, which is reflected in
+// the enrollment status. If the student has not passed the prerequisites of any
+// course, no enrollments are made and a *PrerequisiteError is returned. All
+// enrollments are written in a single transaction, so either every course is added
+// or none are. The enrollments are normalized first (see normalizeEnrollment); if any
+// is invalid, a *ValidationError listing every invalid field is returned.
+func enrollStudent(student Student, term Term, courses []Course) ([]Enrollment, error) {
+	errs := &ValidationError{}
+	courses = append([]Course{}, courses...)
+	for i := range courses {
+		e, err := normalizeEnrollment(Enrollment{StudentID: student.ID, CourseCode: courses[i].CourseCode, TermCode: term.Code})
+		if err != nil {
+			errs.addAll(fmt.Sprintf("enrollments[%d]", i), err)
+		}
+		courses[i].CourseCode = e.CourseCode
+		term.Code = e.TermCode
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := `INSERT INTO enrollment(student_id, course_code, term_code, date_enrolled, final_grade)
+			VALUES (?, ?, ?, ?, ?)`
+	waitlistQuery := `INSERT INTO waitlist(student_id, course_code, term_code, date_added) VALUES (?, ?, ?, ?)`
+	partition, err := pm.partitionFor(student.Name)
+	if err != nil {
+		return nil, err
+	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := checkCourseOfferings(ctx, partition, term, courses); err != nil {
+		return nil, wrapError(partition, "enroll student", err)
+	}
+
+	if err := checkPrerequisites(ctx, partition, student, courses); err != nil {
+		return nil, wrapError(partition, "enroll student", err)
+	}
+
+	capacities, err := getCourseCapacities(ctx, partition, courses)
+	if err != nil {
+		return nil, wrapError(partition, "enroll student", err)
+	}
+
+	enrollStmt, err := partition.prepared(ctx, query)
+	if err != nil {
+		return nil, wrapError(partition, "enroll student", err)
+	}
+	waitlistStmt, err := partition.prepared(ctx, waitlistQuery)
+	if err != nil {
+		return nil, wrapError(partition, "enroll student", err)
+	}
+
+	// reserve seats in capacity-limited courses before writing to the student's
+	// partition; courses that are full are waitlisted instead.
+	var reserved []string
+	statuses := make([]EnrollmentStatus, len(courses))
 	for i := range courses {
+		statuses[i] = EnrollmentStatusEnrolled
+		capacity := capacities[courses[i].CourseCode]
+		if capacity == 0 {
+			continue
+		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		ok, err := reserveSeat(ctx, pm, term.Code, courses[i].CourseCode, capacity)
+		if err != nil {
+			releaseSeats(pm, term.Code, reserved)
+			return nil, wrapError(pm.GetSeatCounterDatabase(), "reserve seat", err)
+		}
+		if ok {
+			reserved = append(reserved, courses[i].CourseCode)
+		} else {
+			statuses[i] = EnrollmentStatusWaitlisted
+		}
+	}
 
+	var enrollments []Enrollment
+	err = partition.WithTx(ctx, func(tx *sql.Tx) error {
+		enrollments = make([]Enrollment, 0, len(courses))
 		now := time.Now().UTC()
+		for i := range courses {
+			var err error
+			if statuses[i] == EnrollmentStatusWaitlisted {
+				err = execEnrollStudentSql(ctx, tx, waitlistStmt, student.ID, courses[i].CourseCode, term.Code, now.Unix())
+			} else {
+				err = execEnrollStudentSql(ctx, tx, enrollStmt, student.ID, courses[i].CourseCode, term.Code, now.Unix(), nil)
+			}
+			if isUniqueViolation(err) {
+				return kindErrorf(ErrDuplicate, "Unable to enroll student: %s is already %s in %s for %s",
+					student.Name, statuses[i], courses[i].CourseCode, term.Code)
+			}
+			if err != nil {
+				return err
+			}
+			enrollments = append(enrollments, Enrollment{
+				StudentID:    student.ID,
+				CourseCode:   courses[i].CourseCode,
+				TermCode:     term.Code,
+				DateEnrolled: time.Unix(now.Unix(), 0).UTC(),
+				Status:       statuses[i],
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		releaseSeats(pm, term.Code, reserved)
+		return nil, wrapError(partition, "enroll student", err)
+	}
 
-		err := execEnrollStudentSql(ctx, partition, sql, student.ID, courses[i].CourseCode, now.Unix(), nil)
+	// offer any seat freed while the student was being waitlisted; the enrollment is
+	// already written, so failures are only logged
+	for i := range enrollments {
+		if enrollments[i].Status != EnrollmentStatusWaitlisted {
+			continue
+		}
+		promoted, err := offerFreeSeats(ctx, pm, term.Code, enrollments[i].CourseCode, capacities[enrollments[i].CourseCode])
 		if err != nil {
-			return err
+			log.Printf("Unable to offer free seats in %s for %s: %v", enrollments[i].CourseCode, term.Code, err)
+		}
+		for _, p := range promoted {
+			if p.partition == partition && p.StudentID == student.ID {
+				enrollments[i].Status = EnrollmentStatusEnrolled
+			}
 		}
 	}
 
-	return nil
+	return enrollments, nil
 }
 
-// execEnrollStudentSql helper function that accepts a context to limit query run time, a pointer to the correct
-// database partition, a query, and arguments that will be safely merged into the query to avoid sql injection.
-func execEnrollStudentSql(ctx context.Context, partition *Database, query string, args ...interface{}) error {
-	s, err := partition.db.Prepare(query)
+// withdrawStudent removes a student from a course in a term, or from the course's
+// waitlist if they were still waiting for a seat. A seat given up in a capacity-limited
+// course is handed to the next student on the waitlist for the same term. The course
+// and term codes are normalized first, see normalizeEnrollment. student is a student
+// returned by a read, see StudentRef.
+func withdrawStudent(student Student, term Term, course Course) error {
+	e, err := normalizeEnrollment(Enrollment{StudentID: student.ID, CourseCode: course.CourseCode, TermCode: term.Code})
 	if err != nil {
 		return err
 	}
-	defer s.Close()
+	course.CourseCode, term.Code = e.CourseCode, e.TermCode
+
+	pm, release := topology.Acquire()
+	defer release()
 
-	res, err := s.ExecContext(ctx, args...)
+	partition, err := pm.partitionFor(student.Name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wasEnrolled bool
+	err = partition.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, partition.rebind(`DELETE FROM enrollment WHERE student_id = ? AND course_code = ? AND term_code = ?`),
+			student.ID, course.CourseCode, term.Code)
+		if err != nil {
+			return err
+		}
+		cnt, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		wasEnrolled = cnt > 0
+		if wasEnrolled {
+			return nil
+		}
+
+		res, err = tx.ExecContext(ctx, partition.rebind(`DELETE FROM waitlist WHERE student_id = ? AND course_code = ? AND term_code = ?`),
+			student.ID, course.CourseCode, term.Code)
+		if err != nil {
+			return err
+		}
+		cnt, err = res.RowsAffected()
+		if err != nil || cnt == 0 {
+			return kindErrorf(ErrNotFound, "Unable to withdraw student: %s is not enrolled in %s for %s", student.Name, course.CourseCode, term.Code)
+		}
+		return nil
+	})
+	if err != nil || !wasEnrolled {
+		return wrapError(partition, "withdraw student", err)
+	}
+
+	capacities, err := getCourseCapacities(ctx, partition, []Course{course})
+	if err != nil {
+		return wrapError(partition, "withdraw student", err)
+	}
+	if capacities[course.CourseCode] == 0 {
+		return nil
+	}
+
+	_, err = fillFreedSeat(ctx, pm, term.Code, course.CourseCode)
+	return err
+}
+
+// execEnrollStudentSql helper function that accepts a context to limit query run time, the transaction
+// on the correct database partition, a statement prepared on that partition, and arguments that will be
+// safely merged into the query to avoid sql injection.
+func execEnrollStudentSql(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, args ...interface{}) error {
+	res, err := tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
 	if err != nil {
 		return err
 	}
 
 	cnt, err := res.RowsAffected()
-	if err != nil || cnt == 0 {
-		return fmt.Errorf("Unable to enroll student")
+	if err != nil {
+		return err
+	}
+	// an insert that writes nothing was ignored as a conflict
+	if cnt == 0 {
+		return kindErrorf(ErrDuplicate, "Unable to enroll student: the enrollment was not written")
 	}
 
 	return nil
@@ -284,25 +588,35 @@ type StudentCourses struct {
 	StudentMobile string
 	CourseCode    sql.NullString
 	CourseName    sql.NullString
+	Capacity      sql.NullInt64
 }
 
 // getCoursesForStudents fetches the courses each student is enrolled in.
 // In this example, all students that were provided are returned, regardless
 // if they are enrolled. Depending on the callers needs, this could easily
 // be modified to return only those enrolled in a course.
+// Students in partitions that are unavailable are left out, and the results for the
+// remaining students are returned along with a *PartialResultError.
 func getCoursesForStudents(students []Student) (map[Student][]Course, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
 	var studentPartitionMap map[string][]Student = make(map[string][]Student)
 
 	// iterate students and build a map of students ids for each db partition. That way we only
 	// have to run one query for each relevant partition to fetch all courses per student.
 	// Will be faster than running query for each student.
 	for i := range students {
-		db := pm.GetDatabaseByPartitionString(students[i].Name)
+		db, err := pm.partitionFor(students[i].Name)
+		if err != nil {
+			return nil, err
+		}
 		studentPartitionMap[db.Name] = append(studentPartitionMap[db.Name], students[i])
 	}
 
 	// create map to contain final results
 	var finalResults map[Student][]Course = make(map[Student][]Course)
+	var partial *PartialResultError
 
 	// now range over student-partition map to build & execute queries; this map
 	// will contain only the db partitions which contain students that are in those partitions.
@@ -322,7 +636,7 @@ func getCoursesForStudents(students []Student) (map[Student][]Course, error) {
 		// fetch all data using an IN clause, so fewer queries to relevant partitioned dbs.
 		// this query ensures we get all students back that we asked for, regardless if
 		// they are enrolled. Makes returning final results easier.
-		sql := `SELECT s.id, s.name, s.mobile, c.code, c.name 
+		sql := `SELECT s.id, s.name, s.mobile, c.code, c.name, c.capacity
 				FROM students AS s
 					LEFT JOIN enrollment AS e ON s.id = e.student_id
 					LEFT JOIN courses AS c on e.course_code = c.code
@@ -334,18 +648,22 @@ func getCoursesForStudents(students []Student) (map[Student][]Course, error) {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		rows, err := partition.db.QueryContext(ctx, sql)
+		rows, err := partition.queryReader(ctx, sql)
+		if isPartitionUnavailable(err) {
+			partial = partial.skip(partition, err)
+			continue
+		}
 		if err != nil {
-			return nil, err
+			return nil, wrapError(partition, "get courses", err)
 		}
 		defer rows.Close()
 
 		// iterate query results and map of results
 		for rows.Next() {
 			sc := StudentCourses{}
-			err := rows.Scan(&sc.StudentID, &sc.StudentName, &sc.StudentMobile, &sc.CourseCode, &sc.CourseName)
+			err := rows.Scan(&sc.StudentID, &sc.StudentName, &sc.StudentMobile, &sc.CourseCode, &sc.CourseName, &sc.Capacity)
 			if err != nil {
-				return nil, err
+				return nil, wrapError(partition, "get courses", err)
 			}
 
 			s := Student{sc.StudentID, sc.StudentName, sc.StudentMobile}
@@ -353,77 +671,195 @@ func getCoursesForStudents(students []Student) (map[Student][]Course, error) {
 			if sc.CourseCode.Valid && sc.CourseName.Valid {
 				c.CourseCode = sc.CourseCode.String
 				c.Name = sc.CourseName.String
+				c.Capacity = int(sc.Capacity.Int64)
 			}
 
 			finalResults[s] = append(finalResults[s], c)
 		}
 		err = rows.Err()
 		if err != nil {
-			return nil, err
+			return nil, wrapError(partition, "get courses", err)
 		}
 	}
 
+	if partial != nil {
+		return finalResults, partial
+	}
 	return finalResults, nil
 }
 
 // addStudent writes a new student to the appropriate database and
-// adds the student identifer to the provided struct.
-func addStudent(student Student) error {
-	sql := "INSERT INTO students(name, mobile) VALUES (?, ?)"
-	partition := pm.GetDatabaseByPartitionString(student.Name)
-
-	s, err := partition.db.Prepare(sql)
+// returns the student with its generated identifier. The student is normalized
+// first, see normalizeStudent.
+func addStudent(student Student) (Student, error) {
+	student, err := normalizeStudent(student)
 	if err != nil {
-		return err
+		return Student{}, err
+	}
+	students, err := addStudents([]Student{student})
+	if err != nil {
+		return Student{}, err
 	}
-	defer s.Close()
+	return students[0], nil
+}
 
+// addStudents writes a batch of students to their database partitions and returns
+// them, with generated identifiers, in the same order they were provided. Students
+// are grouped by partition so each partition is written in a single transaction.
+// Mobile numbers must be unique across every partition; if a number is already used,
+// no student is added and a *DuplicateMobileError is returned. The students are
+// normalized first (see normalizeStudent); if any is invalid, none is added and a
+// *ValidationError listing every invalid field is returned. If writing a partition
+// fails, the students already committed to earlier partitions are deleted again and
+// their mobile numbers released, so no student of the batch is left behind; if that
+// clean up fails too, the error names the partitions that keep their students.
+func addStudents(students []Student) ([]Student, error) {
+	errs := &ValidationError{}
+	created := make([]Student, len(students))
+	for i := range students {
+		s, err := normalizeStudent(students[i])
+		if err != nil {
+			errs.addAll(fmt.Sprintf("students[%d]", i), err)
+		}
+		// ids are generated by the partitions
+		s.ID = 0
+		created[i] = s
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := "INSERT INTO students(name, mobile) VALUES (?, ?)"
+
+	// map each partition to the positions of its students in the input slice, so
+	// the generated ids can be written back in input order.
+	partitionIdx := make(map[*Database][]int)
+	var partitions []*Database
+	for i := range created {
+		partition := pm.GetDatabaseByPartitionString(created[i].Name)
+		if _, ok := partitionIdx[partition]; !ok {
+			partitions = append(partitions, partition)
+		}
+		partitionIdx[partition] = append(partitionIdx[partition], i)
+	}
+
+	// claimsOf lists the mobile numbers of the students in parts, with their ids once
+	// they have been written.
+	claimsOf := func(parts []*Database) []mobileClaim {
+		var claims []mobileClaim
+		for _, partition := range parts {
+			for _, i := range partitionIdx[partition] {
+				if created[i].Mobile != "" {
+					claims = append(claims, mobileClaim{mobile: created[i].Mobile, ref: StudentRef{Partition: partition.Name, ID: created[i].ID}})
+				}
+			}
+		}
+		return claims
+	}
+
+	// claim every mobile number in the index before any student is written, so no
+	// number is given to two students
+	seen := make(map[string]bool)
+	for _, s := range created {
+		if s.Mobile != "" && seen[s.Mobile] {
+			return nil, kindErrorf(ErrDuplicate, "Unable to add students: mobile %s is given to more than one student", s.Mobile)
+		}
+		seen[s.Mobile] = true
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-
-	res, err := s.ExecContext(ctx, student.Name, student.Mobile)
-	if err != nil {
-		return err
+	if err := claimMobiles(ctx, pm, claimsOf(partitions)); err != nil {
+		return nil, err
 	}
 
-	id, err := res.LastInsertId()
-	if err != nil {
+	// undo removes the students committed to the partitions before partitions[k] and
+	// releases every claim except those of students that could not be removed
+	undo := func(k int, err error) error {
+		err = wrapError(partitions[k], "add students", err)
+		released := partitions[k:]
+		var kept []string
+		for _, partition := range partitions[:k] {
+			var batch []Student
+			for _, i := range partitionIdx[partition] {
+				batch = append(batch, created[i])
+			}
+			if removeErr := removeStudents(partition, batch); removeErr != nil {
+				log.Printf("Unable to remove the students added to %s before the batch failed: %v", partition.Name, removeErr)
+				kept = append(kept, partition.Name)
+				continue
+			}
+			released = append(released, partition)
+		}
+		releaseMobiles(pm, claimsOf(released))
+		if len(kept) > 0 {
+			return fmt.Errorf("%w; the students added to %s could not be removed", err, strings.Join(kept, ", "))
+		}
 		return err
 	}
-	student.ID = uint64(id)
-
-	return nil
-}
 
-// getStudents fetches all students.
-func getStudents() ([]Student, error) {
-	sql := `SELECT id, name, mobile
-			FROM students`
+	for k, partition := range partitions {
+		idx := partitionIdx[partition]
 
-	var students []Student
-	for i := range pm.DBs {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		rows, err := pm.DBs[i].db.QueryContext(ctx, sql)
+		stmt, err := partition.prepared(ctx, partition.Dialect.InsertReturningID(query, "id"))
 		if err != nil {
-			return nil, err
+			cancel()
+			return nil, undo(k, err)
 		}
-		defer rows.Close()
 
-		for rows.Next() {
-			s := Student{}
-			err := rows.Scan(&s.ID, &s.Name, &s.Mobile)
-			if err != nil {
-				return nil, err
+		err = partition.WithTx(ctx, func(tx *sql.Tx) error {
+			s := tx.StmtContext(ctx, stmt)
+			for _, i := range idx {
+				id, err := partition.Dialect.insertID(ctx, s, created[i].Name, created[i].Mobile)
+				if err != nil {
+					return err
+				}
+				created[i].ID = uint64(id)
 			}
-			students = append(students, s)
-		}
-		err = rows.Err()
+			return nil
+		})
 		if err != nil {
-			return nil, err
+			cancel()
+			return nil, undo(k, err)
 		}
+
+		// lookups by mobile don't need the ids, so a failure here only leaves them out
+		// of the index
+		if err := confirmMobiles(ctx, pm, claimsOf([]*Database{partition})); err != nil {
+			log.Printf("Unable to record student ids in the mobile index: %v", err)
+		}
+		cancel()
 	}
 
-	return students, nil
+	return created, nil
+}
+
+// removeStudents deletes students that were added to partition by a batch that
+// failed on a later partition. It uses its own context so the students are removed
+// even when the caller's context has already expired.
+func removeStudents(partition *Database, students []Student) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return partition.WithTx(ctx, func(tx *sql.Tx) error {
+		for _, s := range students {
+			_, err := tx.ExecContext(ctx, partition.rebind(`DELETE FROM students WHERE id = ? AND name = ?`), s.ID, s.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getStudents fetches all students. Partitions that are unavailable are skipped; the
+// students from the remaining partitions are returned along with a *PartialResultError.
+func getStudents() ([]Student, error) {
+	sql := `SELECT id, name, mobile
+			FROM students`
+
+	return execGetStudentsInCourseSql(sql)
 }
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestWriteAPIs
+// Run sub test:  	go test -run TestWriteAPIs/TestAddStudentsReturnsIDs
+func TestWriteAPIs(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	// TESTS //
+	t.Run("TestAddStudentsReturnsIDs", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		in := []Student{
+			{Name: "Rob Pike", Mobile: "8885551111"},
+			{Name: "Ken Thompson", Mobile: "8885551112"},
+			{Name: "Russ Cox", Mobile: "8885551114"},
+		}
+		res, err := addStudents(in)
+		if err != nil {
+			t.Fatalf("Expected students to be added, received error: %v", err)
+		}
+		if len(res) != len(in) {
+			t.Fatalf("Expected %d students, received %d", len(in), len(res))
+		}
+		for i := range in {
+			if res[i].Name != in[i].Name {
+				t.Errorf("Expected student %d to be %s, received %s", i, in[i].Name, res[i].Name)
+			}
+			if res[i].ID == 0 {
+				t.Errorf("Expected %s to have a generated ID", res[i].Name)
+			}
+		}
+		// Rob Pike and Russ Cox share a partition, so their ids are sequential
+		if res[2].ID != res[0].ID+1 {
+			t.Errorf("Expected sequential ids in partition, received %d and %d", res[0].ID, res[2].ID)
+		}
+	})
+
+	t.Run("TestAddStudentsRemovesBatchOnFailure", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		pm, release := topology.Acquire()
+		release()
+		second := pm.GetDatabaseByName("enrollment2.db")
+		_, err := second.db.Exec(`CREATE TRIGGER fail_students BEFORE INSERT ON students BEGIN SELECT RAISE(ABORT, 'forced failure'); END`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := addStudents([]Student{{Name: "Dennis Ritchie", Mobile: "8885552001"}, {Name: "Niklaus Wirth", Mobile: "8885552002"}})
+		if _, dropErr := second.db.Exec(`DROP TRIGGER fail_students`); dropErr != nil {
+			t.Fatal(dropErr)
+		}
+		if err == nil || !strings.Contains(err.Error(), "forced failure") || res != nil {
+			t.Fatalf("Expected the second partition's failure, received %+v, error: %v", res, err)
+		}
+
+		if matches, err := getStudentsByName("Dennis Ritchie"); err != nil || len(matches) != 0 {
+			t.Errorf("Expected the student committed to the first partition to be removed, received: %+v, error: %v", matches, err)
+		}
+		for _, mobile := range []string{"8885552001", "8885552002"} {
+			if matches, err := searchStudentsByMobile(mobile); err != nil || len(matches) != 0 {
+				t.Errorf("Expected the claim on %s to be released, received: %+v, error: %v", mobile, matches, err)
+			}
+		}
+		if _, err := addStudents([]Student{{Name: "Dennis Ritchie", Mobile: "8885552001"}, {Name: "Niklaus Wirth", Mobile: "8885552002"}}); err != nil {
+			t.Errorf("Expected the batch to be added on retry, received error: %v", err)
+		}
+	})
+
+	t.Run("TestAddStudentThenEnroll", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		c, err := addCourse(Course{"DB101", "Databases 101", 0})
+		if err != nil {
+			t.Fatalf("Expected course to be added, received error: %v", err)
+		}
+		if _, err := addCourseOffering(testTerm, c); err != nil {
+			t.Fatalf("Expected course to be offered, received error: %v", err)
+		}
+		s, err := addStudent(Student{Name: "Ian Taylor", Mobile: "8885551115"})
+		if err != nil {
+			t.Fatalf("Expected student to be added, received error: %v", err)
+		}
+		e, err := enrollStudent(s, testTerm, []Course{c})
+		if err != nil {
+			t.Fatalf("Expected student to be enrolled, received error: %v", err)
+		}
+		if len(e) != 1 || e[0].StudentID != s.ID || e[0].CourseCode != c.CourseCode {
+			t.Errorf("Expected enrollment for student %d in %s, received %+v", s.ID, c.CourseCode, e)
+		}
+	})
+}
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestCapacity
+// Run sub test:  	go test -run TestCapacity/TestWithdrawPromotesWaitlist
+func TestCapacity(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	c := addTestCourse(t, Course{"ML301", "Machine Learning 301", 1})
+	// students in different partitions so the seat count must span both
+	students, err := addStudents([]Student{
+		{Name: "Ken Thompson", Mobile: "8885551112"},
+		{Name: "Rob Pike", Mobile: "8885551111"},
+	})
+	if err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+
+	// TESTS //
+	t.Run("TestEnrollWaitlistsWhenFull", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		e, err := enrollStudent(students[0], testTerm, []Course{c})
+		if err != nil || e[0].Status != EnrollmentStatusEnrolled {
+			t.Fatalf("Expected first student to be enrolled, received %+v, error: %v", e, err)
+		}
+		e, err = enrollStudent(students[1], testTerm, []Course{c})
+		if err != nil || e[0].Status != EnrollmentStatusWaitlisted {
+			t.Fatalf("Expected second student to be waitlisted, received %+v, error: %v", e, err)
+		}
+	})
+
+	t.Run("TestWithdrawPromotesWaitlist", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if err := withdrawStudent(students[0], testTerm, c); err != nil {
+			t.Fatalf("Expected student to be withdrawn, received error: %v", err)
+		}
+
+		roster, err := getStudentsInCourse(c.CourseCode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roster) != 1 || roster[0].Name != students[1].Name {
+			t.Errorf("Expected %s to be promoted from the waitlist, received roster: %+v", students[1].Name, roster)
+		}
+
+		pm, release := topology.Acquire()
+		defer release()
+		waitlist, err := getWaitlist(context.Background(), pm, testTerm.Code, c.CourseCode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(waitlist) != 0 {
+			t.Errorf("Expected empty waitlist, received: %+v", waitlist)
+		}
+	})
+
+	osCourse := addTestCourse(t, Course{"OS101", "Operating Systems 101", 1})
+	pm, release := topology.Acquire()
+	release()
+	first := pm.GetDatabaseByName("enrollment1.db")
+
+	t.Run("TestSeatCounterStartsAtExistingEnrollments", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		// an enrollment written without reserving a seat, e.g. by the build
+		_, err := first.db.Exec(`INSERT INTO enrollment(student_id, course_code, term_code, date_enrolled) VALUES (?, ?, ?, ?)`,
+			students[0].ID, osCourse.CourseCode, testTerm.Code, time.Now().Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e, err := enrollStudent(students[1], testTerm, []Course{osCourse})
+		if err != nil || e[0].Status != EnrollmentStatusWaitlisted {
+			t.Fatalf("Expected the existing enrollment to take the only seat, received %+v, error: %v", e, err)
+		}
+	})
+
+	t.Run("TestWaitlistDateInUnixSeconds", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		waitlist, err := getWaitlist(context.Background(), pm, testTerm.Code, osCourse.CourseCode)
+		if err != nil || len(waitlist) != 1 {
+			t.Fatalf("Expected one student on the waitlist, received %+v, error: %v", waitlist, err)
+		}
+		if d := time.Since(waitlist[0].DateAdded); d < 0 || d > time.Minute {
+			t.Errorf("Expected the waitlist date to be now, received: %v", waitlist[0].DateAdded)
+		}
+
+		transcript, err := getTranscript(students[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range transcript {
+			if e.CourseCode == osCourse.CourseCode && !e.DateEnrolled.Equal(waitlist[0].DateAdded) {
+				t.Errorf("Expected the transcript to show the waitlist date %v, received: %v", waitlist[0].DateAdded, e.DateEnrolled)
+			}
+		}
+	})
+
+	t.Run("TestWaitlistInsertOffersFreedSeat", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		// the seat is freed while nobody is waiting yet, as when a withdrawal lands
+		// between a student's capacity check and their waitlist insert
+		if _, err := first.db.Exec(`DELETE FROM enrollment WHERE student_id = ? AND course_code = ?`, students[0].ID, osCourse.CourseCode); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := releaseSeat(ctx, pm, testTerm.Code, osCourse.CourseCode); err != nil {
+			t.Fatal(err)
+		}
+
+		promoted, err := offerFreeSeats(ctx, pm, testTerm.Code, osCourse.CourseCode, osCourse.Capacity)
+		if err != nil || len(promoted) != 1 || promoted[0].StudentID != students[1].ID {
+			t.Fatalf("Expected %s to be offered the freed seat, received %+v, error: %v", students[1].Name, promoted, err)
+		}
+		roster, err := getStudentsInCourse(osCourse.CourseCode)
+		if err != nil || len(roster) != 1 || roster[0].Name != students[1].Name {
+			t.Errorf("Expected %s on the roster, received: %+v, error: %v", students[1].Name, roster, err)
+		}
+	})
+}
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestPrerequisites
+// Run sub test:  	go test -run TestPrerequisites/TestEnrollRejectsMissingPrerequisite
+func TestPrerequisites(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	algo := addTestCourse(t, Course{"ALGO201", "Algorithms 201", 0})
+	ml := addTestCourse(t, Course{"ML301", "Machine Learning 301", 0})
+	if err := addCoursePrerequisite(ml.CourseCode, algo.CourseCode); err != nil {
+		t.Fatalf("Expected prerequisite to be added, received error: %v", err)
+	}
+	s, err := addStudent(Student{Name: "Ken Thompson", Mobile: "8885551112"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TESTS //
+	t.Run("TestEnrollRejectsMissingPrerequisite", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		_, err := enrollStudent(s, testTerm, []Course{ml})
+		var prereqErr *PrerequisiteError
+		if !errors.As(err, &prereqErr) {
+			t.Fatalf("Expected PrerequisiteError, received: %v", err)
+		}
+		missing := prereqErr.Missing[ml.CourseCode]
+		if len(missing) != 1 || missing[0] != algo.CourseCode {
+			t.Errorf("Expected %s to be missing, received: %+v", algo.CourseCode, prereqErr.Missing)
+		}
+	})
+
+	t.Run("TestEnrollRejectsFailingGrade", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if _, err := enrollStudent(s, testTerm, []Course{algo}); err != nil {
+			t.Fatal(err)
+		}
+		if err := setFinalGrade(s, testTerm, algo.CourseCode, "F"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := enrollStudent(s, testTerm, []Course{ml})
+		var prereqErr *PrerequisiteError
+		if !errors.As(err, &prereqErr) {
+			t.Fatalf("Expected PrerequisiteError, received: %v", err)
+		}
+	})
+
+	t.Run("TestPrerequisiteCoursesMustExist", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := [][2]string{{ml.CourseCode, "NOPE101"}, {"NOPE101", algo.CourseCode}}
+		for _, tt := range tests {
+			if err := addCoursePrerequisite(tt[0], tt[1]); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for %s requires %s, received: %v", tt[0], tt[1], err)
+			}
+		}
+		var invalid *ValidationError
+		if err := addCoursePrerequisite(ml.CourseCode, ml.CourseCode); !errors.As(err, &invalid) {
+			t.Errorf("Expected a course requiring itself to be rejected, received: %v", err)
+		}
+	})
+
+	t.Run("TestEnrollAcceptsPassedPrerequisite", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if err := setFinalGrade(s, testTerm, algo.CourseCode, "B+"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enrollStudent(s, testTerm, []Course{ml}); err != nil {
+			t.Errorf("Expected student to be enrolled, received error: %v", err)
+		}
+	})
+}
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestTerms
+// Run sub test:  	go test -run TestTerms/TestRetakeInLaterTerm
+func TestTerms(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	spring := Term{
+		Code:      "2022SP",
+		Name:      "Spring 2022",
+		StartDate: time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2022, time.May, 6, 0, 0, 0, 0, time.UTC),
+	}
+	if _, err := addTerm(spring); err != nil {
+		t.Fatal(err)
+	}
+	db := addTestCourse(t, Course{"DB101", "Databases 101", 0})
+	algo := addTestCourse(t, Course{"ALGO201", "Algorithms 201", 0})
+	if _, err := addCourseOffering(spring, db); err != nil {
+		t.Fatal(err)
+	}
+	s, err := addStudent(Student{Name: "Ken Thompson", Mobile: "8885551112"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TESTS //
+	t.Run("TestEnrollRejectsCourseNotOffered", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if _, err := enrollStudent(s, spring, []Course{algo}); err == nil {
+			t.Errorf("Expected error enrolling in %s for %s, received nil", algo.CourseCode, spring.Code)
+		}
+	})
+
+	t.Run("TestRetakeInLaterTerm", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if _, err := enrollStudent(s, testTerm, []Course{db, algo}); err != nil {
+			t.Fatal(err)
+		}
+		if err := setFinalGrade(s, testTerm, db.CourseCode, "F"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enrollStudent(s, spring, []Course{db}); err != nil {
+			t.Fatalf("Expected student to retake %s, received error: %v", db.CourseCode, err)
+		}
+	})
+
+	t.Run("TestGetCoursesForTerm", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		fall, err := getCoursesForTerm(studentRefOf(s), testTerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fall) != 2 {
+			t.Errorf("Expected 2 courses in %s, received: %+v", testTerm.Code, fall)
+		}
+		res, err := getCoursesForTerm(studentRefOf(s), spring)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 || res[0].CourseCode != db.CourseCode {
+			t.Errorf("Expected only %s in %s, received: %+v", db.CourseCode, spring.Code, res)
+		}
+	})
+}
+
+// HELPER FUNCTIONS //
+
+// testTerm is added to the test partitions by setupTestPartitions.
+var testTerm = Term{
+	Code:      "2021FA",
+	Name:      "Fall 2021",
+	StartDate: time.Date(2021, time.August, 23, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2021, time.December, 17, 0, 0, 0, 0, time.UTC),
+}
+
+// setupTestPartitions points the global topology at two empty databases in a
+// temp directory, with all tables created and testTerm added. The returned func restores
+// the previous state.
+func setupTestPartitions(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "enrollment")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+
+	db1, err := NewDatabase("enrollment1.db", DSNWithTxLock(filepath.Join(dir, "enrollment1.db"), TxLockImmediate), 65, 77)
+	if err != nil {
+		t.Fatalf("Expected to receive database, received error: %v\n", err)
+	}
+	db2, err := NewDatabase("enrollment2.db", DSNWithTxLock(filepath.Join(dir, "enrollment2.db"), TxLockImmediate), 78, 90)
+	if err != nil {
+		t.Fatalf("Expected to receive database, received error: %v\n", err)
+	}
+
+	pm, err := NewPartitionManager([]*Database{db1, db2})
+	if err != nil {
+		t.Fatalf("Expected partition manager, received error: %v\n", err)
+	}
+	prev := topology
+	topology = NewTopology(&pm, "")
+	if err := createTables(pm.DBs); err != nil {
+		t.Fatalf("Expected to create tables, received error: %v\n", err)
+	}
+	if _, err := addTerm(testTerm); err != nil {
+		t.Fatalf("Expected to add term, received error: %v\n", err)
+	}
+
+	return func() {
+		topology.Close()
+		topology = prev
+		os.RemoveAll(dir)
+	}
+}
+
+// addTestCourse adds a course and offers it in testTerm.
+func addTestCourse(t *testing.T, course Course) Course {
+	c, err := addCourse(course)
+	if err != nil {
+		t.Fatalf("Expected course to be added, received error: %v", err)
+	}
+	if _, err := addCourseOffering(testTerm, c); err != nil {
+		t.Fatalf("Expected course to be offered, received error: %v", err)
+	}
+	return c
+}
@@ -4,21 +4,56 @@ import "time"
 
 // Course represents a course available for enrollment.
 type Course struct {
-	CourseCode string
-	Name       string
+	CourseCode string `json:"code"`
+	Name       string `json:"name"`
+	// Capacity is the maximum number of students that can enroll across all
+	// partitions. Zero means the course has no limit.
+	Capacity int `json:"capacity"`
 }
 
+// Term represents an academic term (semester) in which courses are offered.
+type Term struct {
+	Code      string    `json:"code"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+}
+
+// CourseOffering represents a course offered in a term.
+type CourseOffering struct {
+	TermCode   string `json:"term_code"`
+	CourseCode string `json:"course_code"`
+}
+
+// EnrollmentStatus describes whether a student holds a seat in a course or is waiting for one.
+type EnrollmentStatus string
+
+const (
+	EnrollmentStatusEnrolled   EnrollmentStatus = "enrolled"
+	EnrollmentStatusWaitlisted EnrollmentStatus = "waitlisted"
+)
+
 // Enrollment represents a course that a student is enrolled in.
 type Enrollment struct {
-	StudentID    uint64
-	CourseCode   string
-	DateEnrolled time.Time
-	FinalGrade   string
+	StudentID    uint64           `json:"student_id"`
+	CourseCode   string           `json:"course_code"`
+	TermCode     string           `json:"term_code"`
+	DateEnrolled time.Time        `json:"date_enrolled"`
+	FinalGrade   string           `json:"final_grade,omitempty"`
+	Status       EnrollmentStatus `json:"status"`
+}
+
+// WaitlistEntry represents a student waiting for a seat in a full course.
+type WaitlistEntry struct {
+	StudentID  uint64    `json:"student_id"`
+	CourseCode string    `json:"course_code"`
+	TermCode   string    `json:"term_code"`
+	DateAdded  time.Time `json:"date_added"`
 }
 
 // Student represents a university
 type Student struct {
-	ID     uint64
-	Name   string
-	Mobile string
+	ID     uint64 `json:"id"`
+	Name   string `json:"name"`
+	Mobile string `json:"mobile"`
 }
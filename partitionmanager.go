@@ -1,25 +1,153 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
 	"strings"
+	"text/tabwriter"
+)
+
+// The partition key is the upper case first letter of a student's name, so the
+// key space every partition layout must cover is A-Z.
+const (
+	partitionKeySpaceStart rune = 'A'
+	partitionKeySpaceEnd   rune = 'Z'
 )
 
 type PartitionManager struct {
-	partitionMap map[rune]*Database
-	DBs          []*Database
+	partitionMap     map[rune]*Database
+	DBs              []*Database
+	coordinator      *Database
+	stopHealthChecks chan struct{}
+	healthChecksDone chan struct{}
+}
+
+// PartitionLayoutError describes every gap and overlap in a partition layout.
+type PartitionLayoutError struct {
+	Problems []string
+}
+
+func (e *PartitionLayoutError) Error() string {
+	return fmt.Sprintf("invalid partition layout: %s", strings.Join(e.Problems, "; "))
+}
+
+// keyRangeOwner is a named range of partition keys, used to check a layout for
+// gaps and overlaps before any routing is built from it.
+type keyRangeOwner struct {
+	name       string
+	start, end rune
 }
 
-func NewPartitionManager(dbs []*Database) PartitionManager {
+// NewPartitionManager builds the routing table for dbs. Range-routed databases must
+// cover the partition key space exactly once; if any key is unassigned or assigned
+// to more than one database, a *PartitionLayoutError listing every gap and overlap
+// is returned.
+func NewPartitionManager(dbs []*Database) (PartitionManager, error) {
 	pm := PartitionManager{
 		partitionMap: make(map[rune]*Database),
 		DBs:          dbs,
 	}
 
+	var hashed []*Database
+	var owners []keyRangeOwner
+	var coordinators []string
+	for _, v := range dbs {
+		if v.Coordinator {
+			pm.coordinator = v
+			coordinators = append(coordinators, v.Name)
+		}
+		if v.HashWeight > 0 {
+			hashed = append(hashed, v)
+			continue
+		}
+		owners = append(owners, keyRangeOwner{v.Name, v.PartitionStart, v.PartitionEnd})
+	}
+
+	var problems []string
+	if len(dbs) == 0 {
+		problems = append(problems, "no partitions defined")
+	}
+	if len(coordinators) > 1 {
+		problems = append(problems, fmt.Sprintf("only one coordinator partition is allowed, found %s", strings.Join(coordinators, ", ")))
+	}
+	if pm.coordinator == nil && len(dbs) > 0 {
+		pm.coordinator = dbs[0]
+	}
+	if len(hashed) > 0 && len(owners) > 0 {
+		problems = append(problems, "partitions must all be routed by key range or all by hash weight")
+	}
+	if len(hashed) == 0 {
+		problems = append(problems, checkKeyRanges(owners)...)
+	}
+	if len(problems) > 0 {
+		return PartitionManager{}, &PartitionLayoutError{Problems: problems}
+	}
+
 	for _, v := range dbs {
-		pm.buildPartitionMap(v)
+		if v.HashWeight == 0 {
+			pm.buildPartitionMap(v)
+		}
+	}
+	pm.buildHashPartitionMap(hashed)
+
+	return pm, nil
+}
+
+// checkKeyRanges reports keys in the partition key space that no range covers (gaps)
+// or that more than one range covers (overlaps), as well as ranges that extend
+// outside the key space. Consecutive keys with the same problem are reported together.
+func checkKeyRanges(owners []keyRangeOwner) []string {
+	var problems []string
+	for _, o := range owners {
+		if o.start > o.end {
+			problems = append(problems, fmt.Sprintf("%s: range start %c is after end %c", o.name, o.start, o.end))
+		} else if o.start < partitionKeySpaceStart || o.end > partitionKeySpaceEnd {
+			problems = append(problems, fmt.Sprintf("%s: range %s is outside the partition key space %s",
+				o.name, formatKeys(o.start, o.end), formatKeys(partitionKeySpaceStart, partitionKeySpaceEnd)))
+		}
+	}
+
+	// coverage returns the names of the ranges that cover key x
+	coverage := func(x rune) string {
+		var names []string
+		for _, o := range owners {
+			if x >= o.start && x <= o.end {
+				names = append(names, o.name)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+
+	runStart := partitionKeySpaceStart
+	runOwners := coverage(runStart)
+	for x := partitionKeySpaceStart + 1; x <= partitionKeySpaceEnd+1; x++ {
+		var cur string
+		if x <= partitionKeySpaceEnd {
+			cur = coverage(x)
+			if cur == runOwners {
+				continue
+			}
+		}
+
+		switch {
+		case runOwners == "":
+			problems = append(problems, fmt.Sprintf("gap: %s not assigned to a partition", formatKeys(runStart, x-1)))
+		case strings.Contains(runOwners, ", "):
+			problems = append(problems, fmt.Sprintf("overlap: %s assigned to %s", formatKeys(runStart, x-1), runOwners))
+		}
+		runStart, runOwners = x, cur
 	}
 
-	return pm
+	return problems
+}
+
+// formatKeys renders an inclusive range of partition keys, e.g. "keys A-M" or "key Z".
+func formatKeys(start, end rune) string {
+	if start == end {
+		return fmt.Sprintf("key %c", start)
+	}
+	return fmt.Sprintf("keys %c-%c", start, end)
 }
 
 func (pm *PartitionManager) buildPartitionMap(db *Database) {
@@ -28,8 +156,68 @@ func (pm *PartitionManager) buildPartitionMap(db *Database) {
 	}
 }
 
+// buildHashPartitionMap assigns every key in the partition key space to one of the
+// hash-routed databases. Each database owns a number of hash slots equal to its
+// HashWeight, and a key is routed to the owner of the slot its hash falls into.
+func (pm *PartitionManager) buildHashPartitionMap(dbs []*Database) {
+	var slots []*Database
+	for _, db := range dbs {
+		for i := 0; i < db.HashWeight; i++ {
+			slots = append(slots, db)
+		}
+	}
+	if len(slots) == 0 {
+		return
+	}
+
+	for x := partitionKeySpaceStart; x <= partitionKeySpaceEnd; x++ {
+		h := fnv.New32a()
+		h.Write([]byte(string(x)))
+		pm.partitionMap[x] = slots[h.Sum32()%uint32(len(slots))]
+	}
+}
+
+// Describe renders the routing table, one line per run of consecutive partition
+// keys routed to the same database, for operators to check the layout in use.
+func (pm *PartitionManager) Describe() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEYS\tPARTITION\tROUTING")
+
+	runStart := partitionKeySpaceStart
+	for x := partitionKeySpaceStart; x <= partitionKeySpaceEnd; x++ {
+		db := pm.partitionMap[x]
+		if x < partitionKeySpaceEnd && pm.partitionMap[x+1] == db {
+			continue
+		}
+
+		keys := string(runStart)
+		if runStart != x {
+			keys = fmt.Sprintf("%c-%c", runStart, x)
+		}
+		name, routing := "(none)", "-"
+		if db != nil {
+			name, routing = db.Name, "range"
+			if db.HashWeight > 0 {
+				routing = fmt.Sprintf("hash (weight %d)", db.HashWeight)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", keys, name, routing)
+		runStart = x + 1
+	}
+	w.Flush()
+
+	return buf.String()
+}
+
+// GetPartitionKeyFromString returns the partition key of input: its first character,
+// upper-cased, or zero if input is blank.
 func (pm *PartitionManager) GetPartitionKeyFromString(input string) rune {
-	x := []rune(strings.ToUpper(strings.TrimSpace(input)[0:1]))
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return 0
+	}
+	x := []rune(strings.ToUpper(input[0:1]))
 	return x[0]
 }
 
@@ -41,6 +229,18 @@ func (pm *PartitionManager) GetDatabaseByPartitionString(input string) *Database
 	return pm.GetDatabaseByPartitionKey(pm.GetPartitionKeyFromString(input))
 }
 
+// partitionFor returns the partition that stores the rows partitioned by input, e.g.
+// a student's name. It fails with ErrNoPartitionForKey if input's partition key is
+// outside the partition key space.
+func (pm *PartitionManager) partitionFor(input string) (*Database, error) {
+	partition := pm.GetDatabaseByPartitionString(input)
+	if partition == nil {
+		return nil, kindErrorf(ErrNoPartitionForKey, "Unable to find a partition for %q: it must start with a letter from %c to %c",
+			input, partitionKeySpaceStart, partitionKeySpaceEnd)
+	}
+	return partition, nil
+}
+
 func (pm *PartitionManager) GetDatabaseByName(name string) *Database {
 	for i := range pm.DBs {
 		if pm.DBs[i].Name == name {
@@ -50,7 +250,30 @@ func (pm *PartitionManager) GetDatabaseByName(name string) *Database {
 	return nil
 }
 
+// GetSeatCounterDatabase returns the partition that holds the cross-partition
+// seat counters for capacity-limited courses: the coordinator partition.
+func (pm *PartitionManager) GetSeatCounterDatabase() *Database {
+	return pm.coordinator
+}
+
+// GetIndexDatabase returns the partition that holds the global secondary indexes,
+// which map values that must be unique across partitions to the partition that
+// stores them: the coordinator partition.
+func (pm *PartitionManager) GetIndexDatabase() *Database {
+	return pm.coordinator
+}
+
+// Stats returns the connection pool statistics of every partition.
+func (pm *PartitionManager) Stats() []PartitionStats {
+	stats := make([]PartitionStats, len(pm.DBs))
+	for i, db := range pm.DBs {
+		stats[i] = db.Stats()
+	}
+	return stats
+}
+
 func (pm *PartitionManager) CloseConnections() {
+	pm.stopHealthMonitor()
 	for i := range pm.DBs {
 		pm.DBs[i].Close()
 	}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -14,16 +19,17 @@ func TestPartitionManager(t *testing.T) {
 
 	// TEST SET UP //
 	var pm PartitionManager
+	dbs, cleanup := getDatabases(t)
+	defer cleanup()
 
 	// TESTS //
 	t.Run("TestBuildPartitionMap", func(t *testing.T) {
 		fmt.Printf("Running test: %s\n", t.Name())
-		dbs, err := getDatabases()
+		var err error
+		pm, err = NewPartitionManager(dbs)
 		if err != nil {
-			t.Fatalf("Expected to receive databases, received error: %v\n", err)
+			t.Fatalf("Expected partition manager, received error: %v\n", err)
 		}
-
-		pm = NewPartitionManager(dbs)
 		/*
 			for k, v := range pm.partitionMap {
 				fmt.Printf("key: %v; db name: %s\n", k, v.Name)
@@ -76,25 +82,72 @@ func TestPartitionManager(t *testing.T) {
 		}
 	})
 
+	t.Run("TestDescribe", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		res := pm.Describe()
+		var rows []string
+		for _, line := range strings.Split(strings.TrimSpace(res), "\n")[1:] {
+			rows = append(rows, strings.Join(strings.Fields(line), " "))
+		}
+		expected := []string{"A-M enrollment1.db range", "N-Z enrollment2.db range"}
+		if strings.Join(rows, "|") != strings.Join(expected, "|") {
+			t.Errorf("Expected routing table rows %q, received:\n%s", expected, res)
+		}
+	})
+
+	t.Run("TestNewPartitionManagerRejectsGapsAndOverlaps", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		dbs := []*Database{
+			{Name: "first.db", PartitionStart: 'A', PartitionEnd: 'H'},
+			{Name: "second.db", PartitionStart: 'F', PartitionEnd: 'P'},
+			{Name: "third.db", PartitionStart: 'S', PartitionEnd: 'Z'},
+		}
+		_, err := NewPartitionManager(dbs)
+		var layoutErr *PartitionLayoutError
+		if !errors.As(err, &layoutErr) {
+			t.Fatalf("Expected PartitionLayoutError, received: %v", err)
+		}
+		if len(layoutErr.Problems) != 2 {
+			t.Errorf("Expected 1 gap and 1 overlap, received: %v", layoutErr.Problems)
+		}
+		for _, problem := range []string{"overlap: keys F-H", "gap: keys Q-R"} {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("Expected %q to be reported, received: %v", problem, err)
+			}
+		}
+	})
+
 	// TEST TEAR DOWN //
 	pm.CloseConnections()
 }
 
 // HELPER FUNCTIONS //
-func getDatabases() ([]*Database, error) {
-	db1, err := NewDatabase("enrollment1.db", "./enrollment1.db", 65, 77)
-	if err != nil {
-		return nil, err
-	}
 
-	db2, err := NewDatabase("enrollment2.db", "./enrollment2.db", 78, 90)
+// getDatabases opens partitions named like the sample partitions from a config written
+// to a temp directory, so the tests don't open the repo's database files. The returned
+// func removes the directory.
+func getDatabases(t *testing.T) ([]*Database, func()) {
+	dir, err := ioutil.TempDir("", "enrollment")
 	if err != nil {
-		return nil, err
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
 	}
 
-	dbs := make([]*Database, 2)
-	dbs[0] = db1
-	dbs[1] = db2
+	config := fmt.Sprintf(`{
+  "partitions": [
+    { "name": "enrollment1.db", "dsn": %q, "key_range": { "start": "A", "end": "M" } },
+    { "name": "enrollment2.db", "dsn": %q, "key_range": { "start": "N", "end": "Z" } }
+  ]
+}`, filepath.Join(dir, "enrollment1.db"), filepath.Join(dir, "enrollment2.db"))
+	path := filepath.Join(dir, "partitions.json")
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Expected to write config, received error: %v\n", err)
+	}
 
-	return dbs, nil
+	dbs, err := createDBPartitions(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Expected to receive databases, received error: %v\n", err)
+	}
+	return dbs, func() { os.RemoveAll(dir) }
 }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// passingGrades are the final grades that satisfy a prerequisite.
+var passingGrades = []string{"A+", "A", "A-", "B+", "B", "B-", "C+", "C", "C-", "D+", "D", "D-"}
+
+// PrerequisiteError is returned when a student tries to enroll in courses whose
+// prerequisites they have not passed.
+type PrerequisiteError struct {
+	StudentID uint64
+	// Missing maps each rejected course code to the prerequisite course codes
+	// the student has not passed.
+	Missing map[string][]string
+}
+
+func (e *PrerequisiteError) Error() string {
+	codes := make([]string, 0, len(e.Missing))
+	for code := range e.Missing {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	msgs := make([]string, len(codes))
+	for i, code := range codes {
+		msgs[i] = fmt.Sprintf("%s requires %s", code, strings.Join(e.Missing[code], ", "))
+	}
+	return fmt.Sprintf("Unable to enroll student %d, missing prerequisites: %s", e.StudentID, strings.Join(msgs, "; "))
+}
+
+// addCoursePrerequisite records that prerequisiteCode must be passed before a student
+// can enroll in courseCode. Like courses, prerequisites are written to every partition.
+// Both codes are normalized first, see normalizeCourseCode, and both courses must exist.
+// A prerequisite that failed part way through can be retried, see addReplicated.
+func addCoursePrerequisite(courseCode string, prerequisiteCode string) error {
+	errs := &ValidationError{}
+	courseCode, err := normalizeCourseCode(courseCode)
+	if err != nil {
+		errs.add("course_code", "%v", err)
+	}
+	prerequisiteCode, err = normalizeCourseCode(prerequisiteCode)
+	if err != nil {
+		errs.add("prerequisite_code", "%v", err)
+	}
+	if courseCode != "" && courseCode == prerequisiteCode {
+		errs.add("prerequisite_code", "must differ from the course code")
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	return addReplicated(pm, "add prerequisite", replicatedRow{
+		name:    fmt.Sprintf("prerequisite %s of %s", prerequisiteCode, courseCode),
+		table:   "course_prerequisites",
+		columns: []string{"course_code", "prerequisite_code"},
+		key:     []string{"course_code", "prerequisite_code"},
+		values:  []interface{}{courseCode, prerequisiteCode},
+		check: func(ctx context.Context, partition *Database, tx *sql.Tx) error {
+			for _, code := range []string{courseCode, prerequisiteCode} {
+				var n int
+				err := tx.QueryRowContext(ctx, partition.rebind(`SELECT COUNT(*) FROM courses WHERE code = ?`), code).Scan(&n)
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					return kindErrorf(ErrNotFound, "Unable to add prerequisite: course %s does not exist", code)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// checkPrerequisites verifies, against the student's history in their partition, that
+// the student has passed every prerequisite of the provided courses in any term. It
+// returns a *PrerequisiteError listing the missing courses if any prerequisite is not met.
+func checkPrerequisites(ctx context.Context, partition *Database, student Student, courses []Course) error {
+	query := `SELECT p.prerequisite_code
+			FROM course_prerequisites AS p
+			WHERE p.course_code = ?
+				AND NOT EXISTS (
+					SELECT 1 FROM enrollment AS e
+					WHERE e.student_id = ?
+						AND e.course_code = p.prerequisite_code
+						AND e.final_grade IN (?` + strings.Repeat(", ?", len(passingGrades)-1) + `)
+				)
+			ORDER BY p.prerequisite_code`
+
+	missing := make(map[string][]string)
+	for i := range courses {
+		args := []interface{}{courses[i].CourseCode, student.ID}
+		for _, g := range passingGrades {
+			args = append(args, g)
+		}
+
+		rows, err := partition.query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				rows.Close()
+				return err
+			}
+			missing[courses[i].CourseCode] = append(missing[courses[i].CourseCode], code)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		return &PrerequisiteError{StudentID: student.ID, Missing: missing}
+	}
+	return nil
+}
+
+// setFinalGrade records the final grade a student received in a course taken in a term.
+// The grade must be one of finalGrades. student is a student returned by a read, see
+// StudentRef.
+func setFinalGrade(student Student, term Term, courseCode string, grade string) error {
+	errs := &ValidationError{}
+	e, err := normalizeEnrollment(Enrollment{StudentID: student.ID, CourseCode: courseCode, TermCode: term.Code, FinalGrade: grade})
+	if err != nil {
+		errs.addAll("", err)
+	}
+	if e.FinalGrade == "" {
+		errs.add("final_grade", "must not be empty")
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+	courseCode, grade = e.CourseCode, e.FinalGrade
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := `UPDATE enrollment SET final_grade = ? WHERE student_id = ? AND course_code = ? AND term_code = ?`
+	partition, err := pm.partitionFor(student.Name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = partition.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, partition.rebind(query), grade, student.ID, courseCode, term.Code)
+		if err != nil {
+			return err
+		}
+
+		cnt, err := res.RowsAffected()
+		if err != nil || cnt == 0 {
+			return kindErrorf(ErrNotFound, "Unable to set grade: %s is not enrolled in %s for %s", student.Name, courseCode, term.Code)
+		}
+		return nil
+	})
+	return wrapError(partition, "set grade", err)
+}
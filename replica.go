@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replica is a read-only copy of a partition stored in a separate sqlite file. It is
+// kept in sync by periodically shipping a snapshot of the primary to it with the
+// sqlite online backup API, so it may lag the primary by up to the replication interval.
+type Replica struct {
+	Name string
+	db   *sql.DB
+	dsn  string
+
+	mu       sync.RWMutex
+	lastSync time.Time
+	health   healthState
+}
+
+// NewReplica opens the sqlite file that holds a replica. The replica is not used for
+// reads until it has been synced from its primary at least once.
+func NewReplica(name string, connectionString string) (*Replica, error) {
+	db, err := sql.Open(sqliteDriverName, connectionString)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+
+	return &Replica{Name: name, db: db, dsn: connectionString}, nil
+}
+
+// LastSync returns the time the replica last finished copying its primary, or the
+// zero time if it has never been synced.
+func (r *Replica) LastSync() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastSync
+}
+
+// Staleness returns how far behind its primary the replica may be.
+func (r *Replica) Staleness() time.Duration {
+	last := r.LastSync()
+	if last.IsZero() {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Since(last)
+}
+
+// sync copies the primary database into the replica.
+func (r *Replica) sync(ctx context.Context, primary *sql.DB) error {
+	started := time.Now()
+
+	if err := copySQLiteDatabase(ctx, primary, r.db, r.dsn); err != nil {
+		return fmt.Errorf("Unable to copy primary into replica %s: %v", r.Name, err)
+	}
+
+	// the snapshot reflects the primary as of when the copy started
+	r.mu.Lock()
+	r.lastSync = started
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Close closes the replica's connections.
+func (r *Replica) Close() {
+	r.db.Close()
+}
+
+// AddReplica attaches a read replica to the partition. Replicas are only used for
+// reads, and only once they have been synced with SyncReplicas.
+func (i *Database) AddReplica(r *Replica) {
+	i.replicas = append(i.replicas, r)
+}
+
+// Replicas returns the partition's read replicas.
+func (i *Database) Replicas() []*Replica {
+	return i.replicas
+}
+
+// SyncReplicas copies the primary into every replica. Replicas that fail to sync keep
+// their previous snapshot and are skipped for reads once they exceed MaxReadStaleness.
+func (i *Database) SyncReplicas(ctx context.Context) error {
+	var firstErr error
+	for _, r := range i.replicas {
+		if err := r.sync(ctx, i.primaryReader()); err != nil {
+			log.Printf("Unable to sync replica %s of %s: %v", r.Name, i.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// StartReplication syncs the replicas immediately and then every interval until
+// the partition is closed.
+func (i *Database) StartReplication(interval time.Duration) {
+	if len(i.replicas) == 0 || interval <= 0 || i.stopReplication != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	i.stopReplication = stop
+	i.replicationDone = done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			i.SyncReplicas(ctx)
+			cancel()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Reader returns the connection pool to use for a read that tolerates data up to
+// MaxReadStaleness old (any synced replica if MaxReadStaleness is zero). Reads are
+// spread round-robin over the healthy replicas that are fresh enough; if there are
+// none, the read goes to the primary. If the primary is down or its circuit breaker
+// is open, the read fails over to any healthy synced replica regardless of staleness,
+// and ErrPartitionUnavailable or ErrCircuitOpen is returned if there is none.
+func (i *Database) Reader() (*sql.DB, error) {
+	healthy := i.Available()
+	primaryUp := healthy && !i.breaker.rejecting()
+	if primaryUp && len(i.replicas) == 0 {
+		return i.primaryReader(), nil
+	}
+
+	n := len(i.replicas)
+	start := int(atomic.AddUint32(&i.nextReplica, 1))
+	var failover *sql.DB
+	for k := 0; k < n; k++ {
+		r := i.replicas[(start+k)%n]
+		if r.LastSync().IsZero() || !r.health.Available() {
+			continue
+		}
+		if i.MaxReadStaleness == 0 || r.Staleness() <= i.MaxReadStaleness {
+			return r.db, nil
+		}
+		if failover == nil {
+			failover = r.db
+		}
+	}
+
+	if primaryUp {
+		return i.primaryReader(), nil
+	}
+	if failover != nil {
+		log.Printf("Partition %s is down, reading from a stale replica", i.Name)
+		return failover, nil
+	}
+	if !healthy {
+		return nil, i.unavailableError()
+	}
+	return nil, i.circuitOpenError()
+}
+
+// queryReader runs a read query on the pool chosen by Reader. Reads that go to the
+// primary pass through its circuit breaker.
+func (i *Database) queryReader(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	reader, err := i.Reader()
+	if err != nil {
+		return nil, err
+	}
+	if reader == i.primaryReader() {
+		return i.query(ctx, query, args...)
+	}
+	return reader.QueryContext(ctx, i.rebind(query), args...)
+}
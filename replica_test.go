@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestReplicas
+// Run sub test:  	go test -run TestReplicas/TestReaderUsesSyncedReplica
+func TestReplicas(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	dir, err := ioutil.TempDir("", "enrollment")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewDatabase("primary.db", filepath.Join(dir, "primary.db"), 65, 90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica, err := NewReplica("replica.db", filepath.Join(dir, "replica.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.AddReplica(replica)
+
+	if _, err := db.db.Exec(`CREATE TABLE courses (code TEXT PRIMARY KEY, name TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.db.Exec(`INSERT INTO courses VALUES ('DB101', 'Databases 101')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// TESTS //
+	t.Run("TestReaderUsesPrimaryBeforeSync", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if reader, err := db.Reader(); err != nil || reader != db.db {
+			t.Errorf("Expected reads to go to the primary before the replica is synced, error: %v", err)
+		}
+	})
+
+	t.Run("TestReaderUsesSyncedReplica", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if err := db.SyncReplicas(context.Background()); err != nil {
+			t.Fatalf("Expected replica to sync, received error: %v", err)
+		}
+		reader, err := db.Reader()
+		if err != nil || reader != replica.db {
+			t.Fatalf("Expected reads to go to the synced replica, error: %v", err)
+		}
+
+		var name string
+		if err := reader.QueryRow(`SELECT name FROM courses WHERE code = 'DB101'`).Scan(&name); err != nil {
+			t.Fatalf("Expected replica to contain course, received error: %v", err)
+		}
+		if name != "Databases 101" {
+			t.Errorf("Expected Databases 101, received: %s", name)
+		}
+	})
+
+	t.Run("TestReaderSkipsStaleReplica", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		db.MaxReadStaleness = time.Millisecond
+		time.Sleep(5 * time.Millisecond)
+		if reader, err := db.Reader(); err != nil || reader != db.db {
+			t.Errorf("Expected reads to fall back to the primary when the replica is too stale, error: %v", err)
+		}
+	})
+
+	t.Run("TestReaderFailsOverWhenPrimaryDown", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		cfg := HealthCheckConfig{FailureThreshold: 1, RecoveryThreshold: 1}
+		db.health.record(errors.New("disk unavailable"), cfg)
+		defer db.health.record(nil, cfg)
+
+		// the replica is stale, but still preferable to failing the read
+		reader, err := db.Reader()
+		if err != nil || reader != replica.db {
+			t.Errorf("Expected reads to fail over to the replica, error: %v", err)
+		}
+
+		replica.health.record(errors.New("disk unavailable"), cfg)
+		defer replica.health.record(nil, cfg)
+		if _, err := db.Reader(); !errors.Is(err, ErrPartitionUnavailable) {
+			t.Errorf("Expected ErrPartitionUnavailable with no healthy replica, received: %v", err)
+		}
+	})
+
+	// TEST TEAR DOWN //
+	db.Close()
+}
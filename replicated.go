@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// replicatedRow is a row of a table that is replicated to every partition, such as a
+// course or a term. See addReplicated.
+type replicatedRow struct {
+	// name identifies the row in errors, e.g. the course code.
+	name    string
+	table   string
+	columns []string
+	// key are the columns of the table's primary key.
+	key    []string
+	values []interface{}
+	// check, if not nil, runs first in each partition's transaction, e.g. to verify
+	// that the rows the row refers to exist.
+	check func(ctx context.Context, partition *Database, tx *sql.Tx) error
+	// same, if not nil, reports whether the row a partition already has with the same
+	// key is the row being written. Without it, a row with the same key is the same row.
+	same func(ctx context.Context, partition *Database, tx *sql.Tx) (bool, error)
+}
+
+// addReplicated writes a replicated row to every partition, in a transaction per
+// partition. The row is written with an upsert that ignores a row with the same key,
+// so an add that failed part way through converges when it is retried: partitions
+// that already have the row keep it, and the rest are written. If a partition has a
+// different row with the same key, or every partition already has the row, nothing is
+// left to add and ErrDuplicate is returned.
+func addReplicated(pm *PartitionManager, op string, row replicatedRow) error {
+	written := false
+	for _, partition := range pm.DBs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var added bool
+		err := partition.WithTx(ctx, func(tx *sql.Tx) error {
+			added = false
+			if row.check != nil {
+				if err := row.check(ctx, partition, tx); err != nil {
+					return err
+				}
+			}
+
+			insert := partition.Dialect.Upsert(row.table, row.columns, row.key, nil)
+			res, err := tx.ExecContext(ctx, partition.rebind(insert), row.values...)
+			if err != nil {
+				return err
+			}
+			cnt, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			added = cnt > 0
+			if added || row.same == nil {
+				return nil
+			}
+
+			same, err := row.same(ctx, partition, tx)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return kindErrorf(ErrDuplicate, "Unable to %s: a different %s already exists on %s", op, row.name, partition.Name)
+			}
+			return nil
+		})
+		cancel()
+		if err != nil {
+			return wrapError(partition, op, err)
+		}
+		written = written || added
+	}
+
+	if !written {
+		return kindErrorf(ErrDuplicate, "Unable to %s: %s already exists", op, row.name)
+	}
+	return nil
+}
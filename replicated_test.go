@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestReplicated
+// Run sub test:  	go test -run TestReplicated/TestRetryConverges
+func TestReplicated(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	pm, release := topology.Acquire()
+	release()
+	second := pm.GetDatabaseByName("enrollment2.db")
+
+	course := Course{"RTY101", "Retries 101", 10}
+	spring := Term{Code: "2022SP", Name: "Spring 2022", StartDate: time.Date(2022, 1, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2022, 5, 6, 0, 0, 0, 0, time.UTC)}
+
+	prerequisite, err := addCourse(Course{"PRE101", "Prerequisites 101", 0})
+	if err != nil {
+		t.Fatalf("Expected course to be added, received error: %v", err)
+	}
+
+	// TESTS //
+	t.Run("TestRetryConverges", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := []struct {
+			table string
+			add   func() error
+		}{
+			{"courses", func() error {
+				_, err := addCourse(course)
+				return err
+			}},
+			{"terms", func() error {
+				_, err := addTerm(spring)
+				return err
+			}},
+			{"course_offerings", func() error {
+				_, err := addCourseOffering(spring, course)
+				return err
+			}},
+			{"course_prerequisites", func() error {
+				return addCoursePrerequisite(course.CourseCode, prerequisite.CourseCode)
+			}},
+		}
+
+		for _, tt := range tests {
+			// the second partition fails, so the first is left with the row
+			_, err := second.db.Exec(fmt.Sprintf(`CREATE TRIGGER fail_add BEFORE INSERT ON %s BEGIN SELECT RAISE(ABORT, 'forced failure'); END`, tt.table))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.add()
+			if _, dropErr := second.db.Exec(`DROP TRIGGER fail_add`); dropErr != nil {
+				t.Fatal(dropErr)
+			}
+			if err == nil {
+				t.Fatalf("Expected the add to %s to fail on the second partition, received nil", tt.table)
+			}
+
+			if err := tt.add(); err != nil {
+				t.Errorf("Expected the add to %s to converge on retry, received error: %v", tt.table, err)
+			}
+			for _, p := range pm.DBs {
+				var n int
+				if err := p.db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s`, tt.table)).Scan(&n); err != nil || n == 0 {
+					t.Errorf("Expected %s to have a row in %s, received %d, error: %v", p.Name, tt.table, n, err)
+				}
+			}
+			if err := tt.add(); !errors.Is(err, ErrDuplicate) {
+				t.Errorf("Expected an add to %s every partition has to be ErrDuplicate, received: %v", tt.table, err)
+			}
+		}
+	})
+
+	t.Run("TestDifferentRowIsDuplicate", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if _, err := addCourse(Course{course.CourseCode, "Other Retries 101", course.Capacity}); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected a different course with the same code to be ErrDuplicate, received: %v", err)
+		}
+		renamed := spring
+		renamed.Name = "Spring Semester 2022"
+		if _, err := addTerm(renamed); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Expected a different term with the same code to be ErrDuplicate, received: %v", err)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RosterEntry is a student's enrollment in a course.
+type RosterEntry struct {
+	Student Student `json:"student"`
+	// Partition is the partition the student is stored in. Student ids are only unique
+	// within a partition.
+	Partition    string    `json:"partition"`
+	TermCode     string    `json:"term_code"`
+	DateEnrolled time.Time `json:"date_enrolled"`
+	FinalGrade   string    `json:"final_grade,omitempty"`
+}
+
+// EnrollmentBucket counts the enrollments made in the period that starts at Start.
+type EnrollmentBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// RosterStats summarizes the enrollments in a course roster.
+type RosterStats struct {
+	// Headcount is the number of distinct students; a student who took the course in
+	// more than one term is counted once.
+	Headcount int `json:"headcount"`
+	// Grades maps each final grade to the number of enrollments that received it.
+	Grades map[string]int `json:"grades"`
+	// Ungraded is the number of enrollments without a final grade.
+	Ungraded int `json:"ungraded"`
+	// EnrollmentsOverTime counts the enrollments by when they were made. Buckets without
+	// enrollments are left out.
+	EnrollmentsOverTime []EnrollmentBucket `json:"enrollments_over_time"`
+}
+
+// CourseRoster lists the students enrolled in a course across every partition.
+type CourseRoster struct {
+	CourseCode string `json:"course_code"`
+	// TermCode is the term the roster is limited to, or empty for every term.
+	TermCode string        `json:"term_code,omitempty"`
+	Entries  []RosterEntry `json:"entries"`
+	Stats    RosterStats   `json:"stats"`
+}
+
+// getCourseRoster lists the students enrolled in a course, in a term or, if termCode is
+// empty, in every term, with their enrollment date and final grade, ordered by student
+// name. The course code is converted to upper case and both codes are trimmed, as
+// they are when written. The stats count enrollments over time in buckets of the
+// given size.
+// Partitions that are unavailable are skipped; the roster of the remaining partitions
+// is returned along with a *PartialResultError.
+func getCourseRoster(courseCode string, termCode string, bucket time.Duration) (CourseRoster, error) {
+	if bucket <= 0 {
+		return CourseRoster{}, fmt.Errorf("Unable to get roster: bucket size must be positive, received %s", bucket)
+	}
+
+	// a code that is not valid matches no enrollments
+	courseCode, _ = normalizeCourseCode(courseCode)
+	termCode = strings.TrimSpace(termCode)
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := `SELECT s.id, s.name, s.mobile, e.term_code, e.date_enrolled, e.final_grade
+			FROM enrollment AS e
+				JOIN students AS s ON e.student_id = s.id
+			WHERE e.course_code = ?`
+	args := []interface{}{courseCode}
+	if termCode != "" {
+		query += ` AND e.term_code = ?`
+		args = append(args, termCode)
+	}
+
+	roster := CourseRoster{CourseCode: courseCode, TermCode: termCode, Entries: []RosterEntry{}}
+	var partial *PartialResultError
+	for i := range pm.DBs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		entries, err := execGetRosterSql(ctx, pm.DBs[i], query, args...)
+		cancel()
+		if isPartitionUnavailable(err) {
+			partial = partial.skip(pm.DBs[i], err)
+			continue
+		}
+		if err != nil {
+			return CourseRoster{}, wrapError(pm.DBs[i], "get roster", err)
+		}
+		roster.Entries = append(roster.Entries, entries...)
+	}
+
+	sort.SliceStable(roster.Entries, func(x, y int) bool {
+		a, b := roster.Entries[x], roster.Entries[y]
+		if a.Student.Name != b.Student.Name {
+			return a.Student.Name < b.Student.Name
+		}
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		if a.Student.ID != b.Student.ID {
+			return a.Student.ID < b.Student.ID
+		}
+		return a.DateEnrolled.Before(b.DateEnrolled)
+	})
+	roster.Stats = rosterStats(roster.Entries, bucket)
+
+	if partial != nil {
+		return roster, partial
+	}
+	return roster, nil
+}
+
+// execGetRosterSql helper function that runs a roster query against one partition.
+func execGetRosterSql(ctx context.Context, partition *Database, query string, args ...interface{}) ([]RosterEntry, error) {
+	rows, err := partition.queryReader(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []RosterEntry
+	for rows.Next() {
+		e := RosterEntry{Partition: partition.Name}
+		var enrolled int64
+		var grade sql.NullString
+		err := rows.Scan(&e.Student.ID, &e.Student.Name, &e.Student.Mobile, &e.TermCode, &enrolled, &grade)
+		if err != nil {
+			return nil, err
+		}
+		e.DateEnrolled = time.Unix(enrolled, 0).UTC()
+		e.FinalGrade = grade.String
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// rosterStats computes the stats of the roster entries. Enrollment times are bucketed
+// with time.Truncate, so buckets of a day start at midnight UTC and buckets of a week
+// on Monday.
+func rosterStats(entries []RosterEntry, bucket time.Duration) RosterStats {
+	stats := RosterStats{Grades: make(map[string]int), EnrollmentsOverTime: []EnrollmentBucket{}}
+	students := make(map[string]bool)
+	buckets := make(map[time.Time]int)
+	for _, e := range entries {
+		students[fmt.Sprintf("%s/%d", e.Partition, e.Student.ID)] = true
+		if e.FinalGrade == "" {
+			stats.Ungraded++
+		} else {
+			stats.Grades[e.FinalGrade]++
+		}
+		buckets[e.DateEnrolled.Truncate(bucket)]++
+	}
+	stats.Headcount = len(students)
+
+	for start, count := range buckets {
+		stats.EnrollmentsOverTime = append(stats.EnrollmentsOverTime, EnrollmentBucket{Start: start, Count: count})
+	}
+	sort.Slice(stats.EnrollmentsOverTime, func(x, y int) bool {
+		return stats.EnrollmentsOverTime[x].Start.Before(stats.EnrollmentsOverTime[y].Start)
+	})
+	return stats
+}
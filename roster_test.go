@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestRoster
+// Run sub test:  	go test -run TestRoster/TestRosterAcrossPartitions
+func TestRoster(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	spring := Term{
+		Code:      "2022SP",
+		Name:      "Spring 2022",
+		StartDate: time.Date(2022, time.January, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2022, time.May, 6, 0, 0, 0, 0, time.UTC),
+	}
+	if _, err := addTerm(spring); err != nil {
+		t.Fatal(err)
+	}
+	db := addTestCourse(t, Course{"DB101", "Databases 101", 0})
+	if _, err := addCourseOffering(spring, db); err != nil {
+		t.Fatal(err)
+	}
+	// Ken Thompson is in the first partition; Rob Pike and Russ Cox are in the second
+	students, err := addStudents([]Student{
+		{Name: "Ken Thompson", Mobile: "8885551112"},
+		{Name: "Rob Pike", Mobile: "8885551111"},
+		{Name: "Russ Cox", Mobile: "8885551114"},
+	})
+	if err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+	for _, s := range students {
+		if _, err := enrollStudent(s, testTerm, []Course{db}); err != nil {
+			t.Fatalf("Expected %s to be enrolled, received error: %v", s.Name, err)
+		}
+	}
+	if err := setFinalGrade(students[0], testTerm, db.CourseCode, "F"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setFinalGrade(students[1], testTerm, db.CourseCode, "A"); err != nil {
+		t.Fatal(err)
+	}
+	// Ken Thompson retakes the course
+	if _, err := enrollStudent(students[0], spring, []Course{db}); err != nil {
+		t.Fatal(err)
+	}
+
+	// TESTS //
+	t.Run("TestRosterAcrossPartitions", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		roster, err := getCourseRoster(db.CourseCode, "", 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, e := range roster.Entries {
+			names = append(names, e.Student.Name+" "+e.TermCode+" "+e.FinalGrade)
+		}
+		want := []string{"Ken Thompson 2021FA F", "Ken Thompson 2022SP ", "Rob Pike 2021FA A", "Russ Cox 2021FA "}
+		if fmt.Sprint(names) != fmt.Sprint(want) {
+			t.Errorf("Expected roster %v, received: %v", want, names)
+		}
+		if roster.Entries[0].Partition != "enrollment1.db" || roster.Entries[2].Partition != "enrollment2.db" {
+			t.Errorf("Expected entries tagged with their partition, received: %+v", roster.Entries)
+		}
+
+		stats := roster.Stats
+		if stats.Headcount != 3 {
+			t.Errorf("Expected a retake to be counted once, received headcount %d", stats.Headcount)
+		}
+		if stats.Grades["A"] != 1 || stats.Grades["F"] != 1 || len(stats.Grades) != 2 || stats.Ungraded != 2 {
+			t.Errorf("Expected grades A and F and 2 ungraded, received: %+v", stats)
+		}
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		if len(stats.EnrollmentsOverTime) != 1 || stats.EnrollmentsOverTime[0].Count != 4 || !stats.EnrollmentsOverTime[0].Start.Equal(today) {
+			t.Errorf("Expected 4 enrollments today, received: %+v", stats.EnrollmentsOverTime)
+		}
+	})
+
+	t.Run("TestRosterForTerm", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		roster, err := getCourseRoster(db.CourseCode, spring.Code, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(roster.Entries) != 1 || roster.Entries[0].Student.Name != "Ken Thompson" || roster.Stats.Headcount != 1 {
+			t.Errorf("Expected only Ken Thompson in %s, received: %+v", spring.Code, roster)
+		}
+
+		roster, err = getCourseRoster("NOPE", "", time.Hour)
+		if err != nil || len(roster.Entries) != 0 || roster.Stats.Headcount != 0 {
+			t.Errorf("Expected an empty roster, received: %+v, error: %v", roster, err)
+		}
+		if _, err := getCourseRoster(db.CourseCode, "", 0); err == nil {
+			t.Error("Expected a zero bucket size to be rejected, received nil")
+		}
+	})
+
+	t.Run("TestRosterPartialResults", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		pm, release := topology.Acquire()
+		release()
+		second := pm.DBs[1]
+		second.ConfigureCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1})
+		defer second.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		second.withBreaker(func() error { return context.DeadlineExceeded })
+
+		roster, err := getCourseRoster(db.CourseCode, "", 24*time.Hour)
+		var partial *PartialResultError
+		if !errors.As(err, &partial) {
+			t.Fatalf("Expected a *PartialResultError, received: %v", err)
+		}
+		if len(roster.Entries) != 2 || roster.Stats.Headcount != 1 {
+			t.Errorf("Expected Ken Thompson's enrollments only, received: %+v", roster)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchMode selects how searchStudents matches names.
+type SearchMode string
+
+const (
+	// SearchPrefix matches names that start with the search, ignoring case.
+	SearchPrefix SearchMode = "prefix"
+	// SearchSubstring matches names that contain the search, ignoring case.
+	SearchSubstring SearchMode = "substring"
+	// SearchFuzzy matches names, or words in names, that are within a few typos of
+	// the search.
+	SearchFuzzy SearchMode = "fuzzy"
+)
+
+// StudentMatch is a student found by a search.
+type StudentMatch struct {
+	// Partition is the partition the student is stored in. Student ids are only unique
+	// within a partition.
+	Partition string  `json:"partition"`
+	Student   Student `json:"student"`
+	// Distance is the number of edits between the search and the name in a fuzzy
+	// search. It is zero for the other searches.
+	Distance int `json:"distance"`
+}
+
+// Ref returns the ref that identifies the matched student.
+func (m StudentMatch) Ref() StudentRef {
+	return StudentRef{Partition: m.Partition, ID: m.Student.ID}
+}
+
+// searchStudents finds the students whose names match text, ordered by name, or by
+// distance and then name for a fuzzy search. limit caps the number returned; zero
+// returns them all.
+//
+// Every name starting with the same letter is stored in the same partition, so a
+// prefix search only reads the partition its first letter routes to, using the
+// students_name_lower index. Substring and fuzzy searches read every partition.
+// Partitions that are unavailable are skipped; the matches from the remaining
+// partitions are returned along with a *PartialResultError.
+//
+// Case is ignored by comparing lower case names. sqlite's LOWER only folds ASCII
+// letters, so other letters must match case exactly in sqlite partitions.
+func searchStudents(text string, mode SearchMode, limit int) ([]StudentMatch, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("Unable to search students: the search is empty")
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	lower := strings.ToLower(text)
+	partitions := pm.DBs
+	var query string
+	var args []interface{}
+	switch mode {
+	case SearchPrefix:
+		if partition := pm.GetDatabaseByPartitionString(text); partition != nil {
+			partitions = []*Database{partition}
+		}
+		// every name with the prefix sorts between the prefix and the prefix followed
+		// by the largest code point
+		query = `SELECT id, name, mobile
+				FROM students
+				WHERE LOWER(name) >= ? AND LOWER(name) < ?
+				ORDER BY LOWER(name), id`
+		args = []interface{}{lower, lower + "\U0010FFFF"}
+	case SearchSubstring:
+		query = `SELECT id, name, mobile
+				FROM students
+				WHERE LOWER(name) LIKE ? ESCAPE '\'
+				ORDER BY LOWER(name), id`
+		args = []interface{}{"%" + escapeLike(lower) + "%"}
+	case SearchFuzzy:
+		// typos can't be matched with an index, so every name is compared in Go
+		query = `SELECT id, name, mobile
+				FROM students`
+	default:
+		return nil, fmt.Errorf("Unable to search students: unknown search mode %q", mode)
+	}
+	if limit > 0 && mode != SearchFuzzy {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+
+	maxDistance := maxFuzzyDistance(lower)
+	var matches []StudentMatch
+	var partial *PartialResultError
+	for _, partition := range partitions {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		students, err := execGetStudentsSql(ctx, partition, query, args...)
+		cancel()
+		if isPartitionUnavailable(err) {
+			partial = partial.skip(partition, err)
+			continue
+		}
+		if err != nil {
+			return nil, wrapError(partition, "search students", err)
+		}
+
+		for _, s := range students {
+			m := StudentMatch{Partition: partition.Name, Student: s}
+			if mode == SearchFuzzy {
+				m.Distance = fuzzyDistance(lower, strings.ToLower(s.Name))
+				if m.Distance > maxDistance {
+					continue
+				}
+			}
+			matches = append(matches, m)
+		}
+	}
+
+	sortStudentMatches(matches)
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	if partial != nil {
+		return matches, partial
+	}
+	return matches, nil
+}
+
+// searchStudentsByMobile finds the student with the given mobile number. Mobile
+// numbers are unique, so there is at most one. The mobile index (see claimMobiles)
+// names the student's partition, so only the index partition and the student's
+// partition are read.
+func searchStudentsByMobile(mobile string) ([]StudentMatch, error) {
+	mobile, err := normalizeMobile(mobile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to search students: the mobile number %v", err)
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ref, ok, err := lookupMobile(ctx, pm, mobile)
+	if err != nil || !ok {
+		return nil, err
+	}
+	partition := pm.GetDatabaseByName(ref.Partition)
+	if partition == nil {
+		return nil, fmt.Errorf("Unable to search students: the mobile index refers to unknown partition %s", ref.Partition)
+	}
+
+	// the id is not in the index until the student has been written, so the student
+	// is found by mobile number using the students_mobile index
+	query := `SELECT id, name, mobile
+			FROM students
+			WHERE mobile = ?`
+	students, err := execGetStudentsSql(ctx, partition, query, mobile)
+	if err != nil {
+		return nil, wrapError(partition, "search students", err)
+	}
+
+	var matches []StudentMatch
+	for _, s := range students {
+		matches = append(matches, StudentMatch{Partition: partition.Name, Student: s})
+	}
+	return matches, nil
+}
+
+// sortStudentMatches orders matches by distance, then by name ignoring case, then by
+// partition and id, so students who share a name keep a stable order.
+func sortStudentMatches(matches []StudentMatch) {
+	sort.SliceStable(matches, func(x, y int) bool {
+		a, b := matches[x], matches[y]
+		if a.Distance != b.Distance {
+			return a.Distance < b.Distance
+		}
+		if an, bn := strings.ToLower(a.Student.Name), strings.ToLower(b.Student.Name); an != bn {
+			return an < bn
+		}
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		return a.Student.ID < b.Student.ID
+	})
+}
+
+// escapeLike escapes the LIKE wildcards in s with a backslash, for use with
+// ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// maxFuzzyDistance is the number of typos a fuzzy search allows: one, plus one for
+// every five characters in the search.
+func maxFuzzyDistance(search string) int {
+	return 1 + len([]rune(search))/5
+}
+
+// fuzzyDistance is the smallest edit distance between search and either the whole
+// name or one of the words in it, so a search for a surname finds the student.
+func fuzzyDistance(search string, name string) int {
+	best := editDistance(search, name)
+	for _, word := range strings.Fields(name) {
+		if d := editDistance(search, word); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of adjacent
+// characters needed to turn a into b (the optimal string alignment distance).
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows i-2, i-1 and i of the distance matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// minInt returns the smallest of values.
+func minInt(first int, values ...int) int {
+	for _, v := range values {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
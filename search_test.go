@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestSearchStudents
+// Run sub test:  	go test -run TestSearchStudents/TestPrefixReadsOnePartition
+func TestSearchStudents(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	// Ken Thompson and Ian Taylor are in the first partition, the others in the second
+	_, err := addStudents([]Student{
+		{Name: "Ken Thompson", Mobile: "8885551112"},
+		{Name: "Ian Taylor", Mobile: "8885551115"},
+		{Name: "Rob Pike", Mobile: "8885551111"},
+		{Name: "Robert Griesemer", Mobile: "8885551113"},
+		{Name: "Russ Cox", Mobile: "8885551114"},
+	})
+	if err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+
+	pm, release := topology.Acquire()
+	release()
+
+	names := func(matches []StudentMatch) []string {
+		var s []string
+		for _, m := range matches {
+			s = append(s, m.Student.Name)
+		}
+		return s
+	}
+
+	// TESTS //
+	t.Run("TestPrefixReadsOnePartition", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		// a prefix search for names in the second partition succeeds while the first is down
+		first := pm.DBs[0]
+		first.ConfigureCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxRequests: 1})
+		defer first.ConfigureCircuitBreaker(DefaultCircuitBreakerConfig)
+		first.withBreaker(func() error { return context.DeadlineExceeded })
+
+		matches, err := searchStudents("ro", SearchPrefix, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Rob Pike", "Robert Griesemer"}; fmt.Sprint(names(matches)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, names(matches))
+		}
+		if matches[0].Partition != "enrollment2.db" {
+			t.Errorf("Expected the match to carry its partition, received: %+v", matches[0])
+		}
+
+		matches, err = searchStudents("ROB", SearchPrefix, 1)
+		if err != nil || fmt.Sprint(names(matches)) != "[Rob Pike]" {
+			t.Errorf("Expected [Rob Pike], received: %v, error: %v", names(matches), err)
+		}
+	})
+
+	t.Run("TestSubstring", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		matches, err := searchStudents("O", SearchSubstring, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Ian Taylor", "Ken Thompson", "Rob Pike", "Robert Griesemer", "Russ Cox"}; fmt.Sprint(names(matches)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, names(matches))
+		}
+
+		// LIKE wildcards in the search are matched literally
+		matches, err = searchStudents("%", SearchSubstring, 0)
+		if err != nil || len(matches) != 0 {
+			t.Errorf("Expected no matches, received: %v, error: %v", names(matches), err)
+		}
+	})
+
+	t.Run("TestFuzzy", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		matches, err := searchStudents("Tompson", SearchFuzzy, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 || matches[0].Student.Name != "Ken Thompson" || matches[0].Distance != 1 {
+			t.Errorf("Expected Ken Thompson at distance 1, received: %+v", matches)
+		}
+
+		// closer matches come first
+		matches, err = searchStudents("Robet", SearchFuzzy, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Robert Griesemer", "Rob Pike"}; fmt.Sprint(names(matches)) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, names(matches))
+		}
+
+		tests := []struct {
+			a, b string
+			want int
+		}{
+			{"", "abc", 3},
+			{"kitten", "sitting", 3},
+			{"ken", "kne", 1},
+			{"pike", "pike", 0},
+		}
+		for _, tt := range tests {
+			if d := editDistance(tt.a, tt.b); d != tt.want {
+				t.Errorf("Expected distance %d between %q and %q, received: %d", tt.want, tt.a, tt.b, d)
+			}
+		}
+	})
+
+	t.Run("TestMobile", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		matches, err := searchStudentsByMobile("8885551114")
+		if err != nil || fmt.Sprint(names(matches)) != "[Russ Cox]" {
+			t.Errorf("Expected [Russ Cox], received: %v, error: %v", names(matches), err)
+		}
+		matches, err = searchStudentsByMobile("8885550000")
+		if err != nil || len(matches) != 0 {
+			t.Errorf("Expected no matches, received: %v, error: %v", names(matches), err)
+		}
+	})
+
+	t.Run("TestInvalidSearch", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		if _, err := searchStudents("  ", SearchPrefix, 0); err == nil {
+			t.Error("Expected an empty search to be rejected, received nil")
+		}
+		if _, err := searchStudents("Rob", SearchMode("soundex"), 0); err == nil {
+			t.Error("Expected an unknown mode to be rejected, received nil")
+		}
+	})
+
+	t.Run("TestUsesIndexes", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := map[string]string{
+			"students_name_lower": `SELECT id FROM students WHERE LOWER(name) >= 'ro' AND LOWER(name) < 'rp'`,
+			"students_mobile":     `SELECT id FROM students WHERE mobile = '8885551111'`,
+		}
+		for index, query := range tests {
+			rows, err := pm.DBs[1].db.Query("EXPLAIN QUERY PLAN " + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var plan []string
+			for rows.Next() {
+				var id, parent, unused int
+				var detail string
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatal(err)
+				}
+				plan = append(plan, detail)
+			}
+			rows.Close()
+			if !strings.Contains(strings.Join(plan, "\n"), index) {
+				t.Errorf("Expected %s to be used, received plan: %v", index, plan)
+			}
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
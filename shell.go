@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const shellHelp = `Statements end with a semicolon and may span several lines.
+
+  \use <partition>   run statements against the named partition
+  \route <key>       run statements against the partition a key, e.g. a student name, routes to
+  \all               run statements against every partition and union the results (the default)
+  \partitions        show the partition routing table
+  \help              show this help
+  \q                 quit
+`
+
+// sqlShell reads SQL statements and shell commands and runs the statements against
+// one partition or all of them. Results are tagged with the partition they came from.
+type sqlShell struct {
+	cli *cli
+	// target is the name of the partition statements run against; empty means all partitions.
+	target string
+	// interactive is set when reading from a terminal; prompts are only shown then.
+	interactive bool
+	// failed is the first error of a statement or command.
+	failed error
+}
+
+// shell runs the interactive SQL shell until \q or the end of the input. Reading a
+// script that has a failing statement exits with exitError.
+func (c *cli) shell(args []string) error {
+	fs := newFlagSet("shell")
+	if err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	sh := &sqlShell{cli: c, interactive: isTerminal(c.stdin)}
+	if sh.interactive {
+		fmt.Fprint(c.stdout, "Type \\help for help.\n")
+	}
+	if err := sh.run(c.stdin); err != nil {
+		return err
+	}
+	// exit with the code of the first failure, e.g. exitConflict for a duplicate
+	if sh.failed != nil && !sh.interactive {
+		return kindErrorf(sh.failed, "Unable to run every statement")
+	}
+	return nil
+}
+
+// isTerminal reports whether r is a terminal rather than a file or pipe.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (sh *sqlShell) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	var stmt strings.Builder
+	for {
+		sh.prompt(stmt.Len() > 0)
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+
+		if stmt.Len() == 0 && strings.HasPrefix(line, `\`) {
+			if quit := sh.command(line); quit {
+				return nil
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "--") {
+			continue
+		}
+
+		if stmt.Len() > 0 {
+			stmt.WriteString("\n")
+		}
+		stmt.WriteString(line)
+		if strings.HasSuffix(line, ";") {
+			sh.report(sh.execute(stmt.String()))
+			stmt.Reset()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// run a final statement that is missing its semicolon
+	if stmt.Len() > 0 {
+		sh.report(sh.execute(stmt.String()))
+	}
+	return nil
+}
+
+func (sh *sqlShell) prompt(continued bool) {
+	if !sh.interactive {
+		return
+	}
+	target := sh.target
+	if target == "" {
+		target = "all"
+	}
+	if continued {
+		fmt.Fprintf(sh.cli.stdout, "%s-> ", strings.Repeat(" ", len(target)))
+		return
+	}
+	fmt.Fprintf(sh.cli.stdout, "%s=> ", target)
+}
+
+// report prints the error of a statement or command, if any, and carries on.
+func (sh *sqlShell) report(err error) {
+	if err == nil {
+		return
+	}
+	var partial *PartialResultError
+	if !errors.As(err, &partial) && sh.failed == nil {
+		sh.failed = err
+	}
+	sh.cli.exitCode(err)
+}
+
+// command runs a shell command and reports whether the shell should quit.
+func (sh *sqlShell) command(line string) bool {
+	fields := strings.Fields(line)
+	arg := strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, fields[0])), `"'`)
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	switch fields[0] {
+	case `\q`, `\quit`:
+		return true
+	case `\help`, `\?`:
+		fmt.Fprint(sh.cli.stdout, shellHelp)
+	case `\partitions`:
+		fmt.Fprint(sh.cli.stdout, pm.Describe())
+	case `\all`:
+		sh.target = ""
+		fmt.Fprintln(sh.cli.stdout, "Using all partitions")
+	case `\use`:
+		if pm.GetDatabaseByName(arg) == nil {
+			sh.report(fmt.Errorf("Unable to find partition %q", arg))
+			return false
+		}
+		sh.target = arg
+		fmt.Fprintf(sh.cli.stdout, "Using %s\n", arg)
+	case `\route`:
+		var partition *Database
+		if strings.TrimSpace(arg) != "" {
+			partition = pm.GetDatabaseByPartitionString(arg)
+		}
+		if partition == nil {
+			sh.report(fmt.Errorf("Unable to route %q to a partition", arg))
+			return false
+		}
+		sh.target = partition.Name
+		fmt.Fprintf(sh.cli.stdout, "Using %s (routed from %q)\n", partition.Name, arg)
+	default:
+		sh.report(fmt.Errorf("Unknown command %s, see \\help", fields[0]))
+	}
+	return false
+}
+
+// PartialWriteError is returned when a statement that does not return rows fails on
+// one of several partitions it was run against. Each partition is written in its own
+// transaction, so the partitions the statement already ran on keep its changes.
+type PartialWriteError struct {
+	// Committed are the partitions the statement was committed on.
+	Committed []string
+	// Failed is the partition the statement failed on.
+	Failed string
+	Err    error
+	// NotRun are the partitions after Failed, which the statement was not run on.
+	NotRun []string
+}
+
+func (e *PartialWriteError) Error() string {
+	list := func(names []string) string {
+		if len(names) == 0 {
+			return "none"
+		}
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("Unable to write to every partition: %s failed: %v; committed on: %s; not run on: %s",
+		e.Failed, e.Err, list(e.Committed), list(e.NotRun))
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error the write failed with corresponds to target, as for a
+// *PartitionError.
+func (e *PartialWriteError) Is(target error) bool {
+	return (&PartitionError{Partition: e.Failed, Err: e.Err}).Is(target)
+}
+
+// execute runs a statement against the target partitions. For a query, partitions
+// that are unavailable are skipped, and the rows from the others are shown along with
+// a *PartialResultError. A write to several partitions stops at the first partition
+// it fails on, and the partitions it was committed on are shown along with a
+// *PartialWriteError.
+func (sh *sqlShell) execute(stmt string) error {
+	pm, release := topology.Acquire()
+	defer release()
+
+	partitions := pm.DBs
+	if sh.target != "" {
+		partition := pm.GetDatabaseByName(sh.target)
+		if partition == nil {
+			return fmt.Errorf("Unable to find partition %q; it may have been removed by a reload", sh.target)
+		}
+		partitions = []*Database{partition}
+	}
+
+	query := returnsRows(stmt)
+	run := sh.execPartition
+	if query {
+		run = sh.queryPartition
+	}
+
+	res := shellResult{}
+	var partial *PartialResultError
+	var committed []string
+	for k, partition := range partitions {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := run(ctx, partition, stmt, &res)
+		cancel()
+		if isPartitionUnavailable(err) && query && len(partitions) > 1 {
+			partial = partial.skip(partition, err)
+			continue
+		}
+		if err != nil && !query && len(partitions) > 1 {
+			writeErr := &PartialWriteError{Committed: committed, Failed: partition.Name, Err: err}
+			for _, p := range partitions[k+1:] {
+				writeErr.NotRun = append(writeErr.NotRun, p.Name)
+			}
+			if len(committed) == 0 {
+				return writeErr
+			}
+			if resultErr := sh.cli.result(res.objects(), res.table(), nil); resultErr != nil {
+				return resultErr
+			}
+			return writeErr
+		}
+		if err != nil {
+			return wrapError(partition, "run statement", err)
+		}
+		committed = append(committed, partition.Name)
+	}
+
+	var err error
+	if partial != nil {
+		err = partial
+	}
+	return sh.cli.result(res.objects(), res.table(), err)
+}
+
+// shellResult holds the rows of a statement from every partition it ran against.
+type shellResult struct {
+	columns    []string
+	partitions []string
+	rows       [][]interface{}
+}
+
+func (r *shellResult) add(partition string, columns []string, rows [][]interface{}) error {
+	if r.columns == nil {
+		r.columns = columns
+	} else if strings.Join(columns, ",") != strings.Join(r.columns, ",") {
+		return fmt.Errorf("Unable to union results: columns %s differ from %s", strings.Join(columns, ", "), strings.Join(r.columns, ", "))
+	}
+	for _, row := range rows {
+		r.partitions = append(r.partitions, partition)
+		r.rows = append(r.rows, row)
+	}
+	return nil
+}
+
+func (r *shellResult) table() table {
+	t := table{header: append([]string{"PARTITION"}, r.columns...)}
+	for k, row := range r.rows {
+		cells := []string{r.partitions[k]}
+		for _, v := range row {
+			cells = append(cells, formatValue(v))
+		}
+		t.rows = append(t.rows, cells)
+	}
+	return t
+}
+
+func (r *shellResult) objects() []map[string]interface{} {
+	objects := make([]map[string]interface{}, len(r.rows))
+	for k, row := range r.rows {
+		o := map[string]interface{}{"partition": r.partitions[k]}
+		for i, v := range row {
+			o[r.columns[i]] = v
+		}
+		objects[k] = o
+	}
+	return objects
+}
+
+// queryPartition runs a query against a partition's primary.
+func (sh *sqlShell) queryPartition(ctx context.Context, partition *Database, stmt string, res *shellResult) error {
+	rows, err := partition.query(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var values [][]interface{}
+	for rows.Next() {
+		row := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, v := range row {
+			// text columns are returned as bytes; show them as strings
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+		values = append(values, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return res.add(partition.Name, columns, values)
+}
+
+// execPartition runs a statement that does not return rows in a transaction on a
+// partition and records the number of rows it affected.
+func (sh *sqlShell) execPartition(ctx context.Context, partition *Database, stmt string, res *shellResult) error {
+	var affected int64
+	err := partition.WithTx(ctx, func(tx *sql.Tx) error {
+		r, err := tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+		affected, err = r.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return res.add(partition.Name, []string{"rows_affected"}, [][]interface{}{{affected}})
+}
+
+// returnsRows reports whether a statement returns rows, judging by its first keyword.
+func returnsRows(stmt string) bool {
+	fields := strings.Fields(stmt)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(strings.TrimRight(fields[0], ";")) {
+	case "SELECT", "WITH", "VALUES", "PRAGMA", "EXPLAIN", "SHOW":
+		return true
+	}
+	return false
+}
+
+// formatValue renders a column value for table output.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestShell
+// Run sub test:  	go test -run TestShell/TestUnionAcrossPartitions
+func TestShell(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	if _, err := addStudents([]Student{{Name: "Ken Thompson", Mobile: "8885551112"}, {Name: "Rob Pike", Mobile: "8885551111"}}); err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+
+	shell := func(format string, input string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCommand([]string{"shell"}, format, strings.NewReader(input), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	// TESTS //
+	t.Run("TestUnionAcrossPartitions", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		code, stdout, stderr := shell("json", "SELECT name\nFROM students\nORDER BY name;\n")
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+
+		var rows []map[string]string
+		if err := json.Unmarshal([]byte(stdout), &rows); err != nil {
+			t.Fatalf("Expected JSON output, received %q, error: %v", stdout, err)
+		}
+		want := []map[string]string{
+			{"partition": "enrollment1.db", "name": "Ken Thompson"},
+			{"partition": "enrollment2.db", "name": "Rob Pike"},
+		}
+		if fmt.Sprint(rows) != fmt.Sprint(want) {
+			t.Errorf("Expected %v, received: %v", want, rows)
+		}
+	})
+
+	t.Run("TestUseAndRoute", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		input := strings.Join([]string{
+			`\use enrollment2.db`,
+			`SELECT name FROM students;`,
+			`\route "Ken"`,
+			`UPDATE students SET mobile = '5550000';`,
+			`\all`,
+			`SELECT mobile FROM students ORDER BY mobile;`,
+		}, "\n")
+		code, stdout, stderr := shell("table", input)
+		if code != exitOK {
+			t.Fatalf("Expected exit code %d, received %d: %s", exitOK, code, stderr)
+		}
+
+		for _, s := range []string{
+			"enrollment2.db  Rob Pike",
+			"Using enrollment1.db (routed from \"Ken\")",
+			"enrollment1.db  1",
+			"enrollment1.db  5550000",
+			"enrollment2.db  +18885551111",
+		} {
+			if !strings.Contains(stdout, s) {
+				t.Errorf("Expected output to contain %q, received:\n%s", s, stdout)
+			}
+		}
+		if strings.Contains(stdout, "enrollment1.db  Ken Thompson") {
+			t.Errorf("Expected \\use to limit the query to enrollment2.db, received:\n%s", stdout)
+		}
+	})
+
+	t.Run("TestScriptFailure", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		code, stdout, stderr := shell("table", "SELEC 1;\n\\use nope\nSELECT 1 AS one;\n")
+		if code != exitError {
+			t.Errorf("Expected exit code %d, received %d", exitError, code)
+		}
+		if !strings.Contains(stderr, "syntax error") || !strings.Contains(stderr, `"nope"`) {
+			t.Errorf("Expected both errors to be reported, received: %s", stderr)
+		}
+		if !strings.Contains(stdout, "one") {
+			t.Errorf("Expected the shell to carry on after an error, received:\n%s", stdout)
+		}
+	})
+
+	t.Run("TestPartialWriteReportsPartitions", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		pm, release := topology.Acquire()
+		release()
+		// the course already exists in the second partition only
+		if _, err := pm.DBs[1].db.Exec(`INSERT INTO courses(code, name) VALUES ('SH101', 'Shells 101')`); err != nil {
+			t.Fatal(err)
+		}
+
+		code, stdout, stderr := shell("table", "INSERT INTO courses(code, name) VALUES ('SH101', 'Shells 101');\n")
+		if code != exitConflict {
+			t.Errorf("Expected exit code %d, received %d", exitConflict, code)
+		}
+		if want := "enrollment1.db  1"; !strings.Contains(stdout, want) {
+			t.Errorf("Expected the committed partition's rows affected, received:\n%s", stdout)
+		}
+		for _, want := range []string{"enrollment2.db failed", "committed on: enrollment1.db", "not run on: none"} {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("Expected %q to be reported, received: %s", want, stderr)
+			}
+		}
+
+		var n int
+		if err := pm.DBs[0].db.QueryRow(`SELECT COUNT(*) FROM courses WHERE code = 'SH101'`).Scan(&n); err != nil || n != 1 {
+			t.Errorf("Expected the write to stay committed on enrollment1.db, received %d, error: %v", n, err)
+		}
+	})
+
+	t.Run("TestReturnsRows", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := map[string]bool{
+			"SELECT 1;":                              true,
+			"  with x AS (SELECT 1) SELECT * FROM x": true,
+			"PRAGMA journal_mode;":                   true,
+			"DELETE FROM students;":                  false,
+			"":                                       false,
+		}
+		for stmt, want := range tests {
+			if got := returnsRows(stmt); got != want {
+				t.Errorf("Expected returnsRows(%q) to be %t", stmt, want)
+			}
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -0,0 +1,29 @@
+package main
+
+// The sqlite driver is chosen at build time. By default the app uses
+// github.com/mattn/go-sqlite3, which requires cgo (see sqlite_cgo.go). Building with
+// the sqlite_purego tag uses the pure Go modernc.org/sqlite driver instead, so the
+// app can be built with CGO_ENABLED=0 (see sqlite_purego.go):
+//
+//	CGO_ENABLED=0 go build -tags sqlite_purego
+//
+// Each driver file provides sqliteDriverName, sqliteParam, sqliteErrorCode,
+// sqliteExtendedErrorCode and copySQLiteDatabase.
+
+// primary sqlite result codes, see https://www.sqlite.org/rescode.html
+const (
+	sqliteBusy       = 5
+	sqliteLocked     = 6
+	sqliteIOErr      = 10
+	sqliteCorrupt    = 11
+	sqliteFull       = 13
+	sqliteCantOpen   = 14
+	sqliteConstraint = 19
+	sqliteNotADB     = 26
+)
+
+// extended sqlite result codes of constraint violations
+const (
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
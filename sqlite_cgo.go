@@ -0,0 +1,77 @@
+//go:build !sqlite_purego
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const sqliteDriverName = "sqlite3"
+
+// sqliteParam returns the connection string parameter that sets a pragma on every
+// new connection.
+func sqliteParam(pragma string, value string) (string, string) {
+	return "_" + pragma, value
+}
+
+// sqliteErrorCode returns the primary sqlite result code of err, if err came from sqlite.
+func sqliteErrorCode(err error) (int, bool) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return int(sqliteErr.Code), true
+	}
+	return 0, false
+}
+
+// sqliteExtendedErrorCode returns the extended sqlite result code of err, if err came
+// from sqlite.
+func sqliteExtendedErrorCode(err error) (int, bool) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return int(sqliteErr.ExtendedCode), true
+	}
+	return 0, false
+}
+
+// copySQLiteDatabase copies src into dst with the sqlite online backup API.
+func copySQLiteDatabase(ctx context.Context, src *sql.DB, dst *sql.DB, dstConnectionString string) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dc interface{}) error {
+		return srcConn.Raw(func(sc interface{}) error {
+			d, ok := dc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("destination is not a sqlite connection")
+			}
+			s, ok := sc.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("source is not a sqlite connection")
+			}
+
+			backup, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
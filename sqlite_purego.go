@@ -0,0 +1,71 @@
+//go:build sqlite_purego
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+const sqliteDriverName = "sqlite"
+
+// sqliteParam returns the connection string parameter that sets a pragma on every
+// new connection.
+func sqliteParam(pragma string, value string) (string, string) {
+	return "_pragma", fmt.Sprintf("%s(%s)", pragma, value)
+}
+
+// sqliteErrorCode returns the primary sqlite result code of err, if err came from sqlite.
+func sqliteErrorCode(err error) (int, bool) {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// the driver reports extended result codes; the low byte is the primary code
+		return sqliteErr.Code() & 0xff, true
+	}
+	return 0, false
+}
+
+// sqliteExtendedErrorCode returns the extended sqlite result code of err, if err came
+// from sqlite.
+func sqliteExtendedErrorCode(err error) (int, bool) {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code(), true
+	}
+	return 0, false
+}
+
+// copySQLiteDatabase copies src into dst with the sqlite online backup API. The
+// driver opens its own connection to the destination, so dst is not used.
+func copySQLiteDatabase(ctx context.Context, src *sql.DB, dst *sql.DB, dstConnectionString string) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return srcConn.Raw(func(sc interface{}) error {
+		s, ok := sc.(interface {
+			NewBackup(string) (*sqlite.Backup, error)
+		})
+		if !ok {
+			return fmt.Errorf("source is not a sqlite connection")
+		}
+
+		backup, err := s.NewBackup(dstConnectionString)
+		if err != nil {
+			return err
+		}
+		for more := true; more; {
+			if more, err = backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+		}
+		return backup.Finish()
+	})
+}
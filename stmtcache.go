@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+)
+
+// stmtCache holds the statements prepared on a partition's write pool, keyed by
+// query text, so queries that run on every write are only prepared once.
+type stmtCache struct {
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// prepared returns the partition's cached statement for query, preparing it on first
+// use. Statements belong to the write pool and are bound to a transaction with
+// tx.StmtContext. Call it before starting the transaction: preparing a new statement
+// needs a connection, and the pool may have only the one the transaction holds.
+func (i *Database) prepared(ctx context.Context, query string) (*sql.Stmt, error) {
+	i.stmts.mu.Lock()
+	defer i.stmts.mu.Unlock()
+
+	if s, ok := i.stmts.stmts[query]; ok {
+		return s, nil
+	}
+
+	s, err := i.db.PrepareContext(ctx, i.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	if i.stmts.stmts == nil {
+		i.stmts.stmts = make(map[string]*sql.Stmt)
+	}
+	i.stmts.stmts[query] = s
+	return s, nil
+}
+
+// closeStatements closes every cached statement.
+func (i *Database) closeStatements() {
+	i.stmts.mu.Lock()
+	defer i.stmts.mu.Unlock()
+
+	for query, s := range i.stmts.stmts {
+		if err := s.Close(); err != nil {
+			log.Printf("Unable to close statement on %s: %v", i.Name, err)
+		}
+		delete(i.stmts.stmts, query)
+	}
+}
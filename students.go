@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StudentRef identifies a student across every partition. Student ids are only unique
+// within a partition, so a student is identified by their partition and id. A student
+// cannot be renamed into another partition (see updateStudent), so their ref does not
+// change.
+//
+// Only the reads take a ref. The writes (enrollStudent, withdrawStudent, setFinalGrade,
+// updateStudent and deleteStudent) take the Student a read returned, whose name routes
+// the write to the same partition the ref names, for the reason above, and whose id
+// identifies them within it.
+type StudentRef struct {
+	Partition string `json:"partition"`
+	ID        uint64 `json:"id"`
+}
+
+// String formats the ref as partition/id, e.g. enrollment1.db/3.
+func (r StudentRef) String() string {
+	return fmt.Sprintf("%s/%d", r.Partition, r.ID)
+}
+
+// parseStudentRef parses a ref formatted by StudentRef.String, and reports whether s
+// is one.
+func parseStudentRef(s string) (StudentRef, bool) {
+	i := strings.LastIndex(s, "/")
+	if i <= 0 {
+		return StudentRef{}, false
+	}
+	id, err := strconv.ParseUint(s[i+1:], 10, 64)
+	if err != nil || id == 0 {
+		return StudentRef{}, false
+	}
+	return StudentRef{Partition: s[:i], ID: id}, true
+}
+
+// studentRefOf returns the ref of a student, routed by their name. The partition is
+// empty if the name does not route to one.
+func studentRefOf(student Student) StudentRef {
+	pm, release := topology.Acquire()
+	defer release()
+
+	ref := StudentRef{ID: student.ID}
+	if partition := pm.GetDatabaseByPartitionString(student.Name); partition != nil {
+		ref.Partition = partition.Name
+	}
+	return ref
+}
+
+// AmbiguousStudentError is returned when a name is looked up to find one student but
+// more than one student has the name.
+type AmbiguousStudentError struct {
+	Name string
+	// Candidates are the students with the name, with their partitions.
+	Candidates []StudentMatch
+}
+
+func (e *AmbiguousStudentError) Error() string {
+	candidates := make([]string, len(e.Candidates))
+	for i, m := range e.Candidates {
+		candidates[i] = fmt.Sprintf("%s (mobile %s)", m.Ref(), m.Student.Mobile)
+	}
+	return fmt.Sprintf("%d students are named %s: %s", len(e.Candidates), e.Name, strings.Join(candidates, ", "))
+}
+
+// getStudent fetches the student identified by ref.
+func getStudent(ref StudentRef) (Student, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition := pm.GetDatabaseByName(ref.Partition)
+	if partition == nil {
+		return Student{}, kindErrorf(ErrNotFound, "Unable to find student %s: no partition named %s", ref, ref.Partition)
+	}
+	query := `SELECT id, name, mobile
+			FROM students
+			WHERE id = ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	students, err := execGetStudentsSql(ctx, partition, query, ref.ID)
+	if err != nil {
+		return Student{}, wrapError(partition, "get student", err)
+	}
+	if len(students) == 0 {
+		return Student{}, kindErrorf(ErrNotFound, "Unable to find student %s", ref)
+	}
+	return students[0], nil
+}
+
+// getStudentsByName fetches the students with the given name, ordered by id, as
+// candidates to choose from: different people can share a name. Every student with
+// the same name is stored in the same partition, so only that partition is read.
+// Whitespace in the name is collapsed first, as it is when a student is written.
+func getStudentsByName(name string) ([]StudentMatch, error) {
+	name = strings.Join(strings.Fields(name), " ")
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition, err := pm.partitionFor(name)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT id, name, mobile
+			FROM students
+			WHERE name = ?
+			ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	students, err := execGetStudentsSql(ctx, partition, query, name)
+	if err != nil {
+		return nil, wrapError(partition, "get students", err)
+	}
+
+	matches := make([]StudentMatch, len(students))
+	for i, s := range students {
+		matches[i] = StudentMatch{Partition: partition.Name, Student: s}
+	}
+	return matches, nil
+}
+
+// findStudentByName fetches the only student with the given name. If more than one
+// student has the name, an *AmbiguousStudentError listing them is returned, so the
+// caller can choose one by ref rather than act on the wrong person.
+func findStudentByName(name string) (StudentMatch, error) {
+	matches, err := getStudentsByName(name)
+	if err != nil {
+		return StudentMatch{}, err
+	}
+
+	switch len(matches) {
+	case 0:
+		return StudentMatch{}, kindErrorf(ErrNotFound, "Unable to find student %s", name)
+	case 1:
+		return matches[0], nil
+	}
+	return StudentMatch{}, &AmbiguousStudentError{Name: name, Candidates: matches}
+}
+
+// updateStudent changes the name and mobile of a student to those of updated and
+// returns the updated student. A student cannot be renamed to a name that is stored
+// in a different partition, since their id is only unique within their partition.
+// If another student already uses the new mobile number, a *DuplicateMobileError is
+// returned. updated is normalized first, see normalizeStudent. student is a student
+// returned by a read, see StudentRef; if they were renamed since, ErrNotFound is
+// returned.
+func updateStudent(student Student, updated Student) (Student, error) {
+	updated, err := normalizeStudent(updated)
+	if err != nil {
+		return Student{}, err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition, err := pm.partitionFor(student.Name)
+	if err != nil {
+		return Student{}, err
+	}
+	if target := pm.GetDatabaseByPartitionString(updated.Name); target != partition {
+		return Student{}, kindErrorf(ErrConstraint, "Unable to update student: renaming %s to %s would move them from %s to %s",
+			student.Name, updated.Name, partition.Name, target.Name)
+	}
+
+	query := `UPDATE students SET name = ?, mobile = ? WHERE id = ? AND name = ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// a new mobile number is claimed in the index before the student is updated, and
+	// the old one released after
+	var current sql.NullString
+	err = partition.withBreaker(func() error {
+		return partition.primaryReader().QueryRowContext(ctx, partition.rebind(`SELECT mobile FROM students WHERE id = ? AND name = ?`),
+			student.ID, student.Name).Scan(&current)
+	})
+	if err == sql.ErrNoRows {
+		return Student{}, kindErrorf(ErrNotFound, "Unable to update student: no student %s with id %d", student.Name, student.ID)
+	}
+	if err != nil {
+		return Student{}, wrapError(partition, "update student", err)
+	}
+	mobile := current.String
+	ref := StudentRef{Partition: partition.Name, ID: student.ID}
+	var claimed, released []mobileClaim
+	if updated.Mobile != mobile {
+		if updated.Mobile != "" {
+			claimed = []mobileClaim{{mobile: updated.Mobile, ref: ref}}
+		}
+		if mobile != "" {
+			released = []mobileClaim{{mobile: mobile, ref: ref}}
+		}
+	}
+	if err := claimMobiles(ctx, pm, claimed); err != nil {
+		return Student{}, err
+	}
+
+	err = partition.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, partition.rebind(query), updated.Name, updated.Mobile, student.ID, student.Name)
+		if err != nil {
+			return err
+		}
+
+		cnt, err := res.RowsAffected()
+		if err != nil || cnt == 0 {
+			return kindErrorf(ErrNotFound, "Unable to update student: no student %s with id %d", student.Name, student.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		releaseMobiles(pm, claimed)
+		return Student{}, wrapError(partition, "update student", err)
+	}
+	releaseMobiles(pm, released)
+
+	updated.ID = student.ID
+	return updated, nil
+}
+
+// deleteStudent withdraws a student from every course they are enrolled in, so their
+// seats are handed to waitlisted students, and then removes the student and their
+// waitlist entries, and frees their mobile number for other students. student is a
+// student returned by a read, see StudentRef.
+func deleteStudent(student Student) error {
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition, err := pm.partitionFor(student.Name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := partition.query(ctx, `SELECT course_code, term_code FROM enrollment WHERE student_id = ?`, student.ID)
+	if err != nil {
+		return wrapError(partition, "delete student", err)
+	}
+	var enrollments []Enrollment
+	for rows.Next() {
+		e := Enrollment{}
+		if err := rows.Scan(&e.CourseCode, &e.TermCode); err != nil {
+			rows.Close()
+			return wrapError(partition, "delete student", err)
+		}
+		enrollments = append(enrollments, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return wrapError(partition, "delete student", err)
+	}
+
+	for _, e := range enrollments {
+		if err := withdrawStudent(student, Term{Code: e.TermCode}, Course{CourseCode: e.CourseCode}); err != nil {
+			return err
+		}
+	}
+
+	var mobile sql.NullString
+	err = partition.WithTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, partition.rebind(`SELECT mobile FROM students WHERE id = ? AND name = ?`), student.ID, student.Name).Scan(&mobile)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, partition.rebind(`DELETE FROM waitlist WHERE student_id = ?`), student.ID); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, partition.rebind(`DELETE FROM students WHERE id = ? AND name = ?`), student.ID, student.Name)
+		if err != nil {
+			return err
+		}
+		cnt, err := res.RowsAffected()
+		if err != nil || cnt == 0 {
+			return kindErrorf(ErrNotFound, "Unable to delete student: no student %s with id %d", student.Name, student.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return wrapError(partition, "delete student", err)
+	}
+
+	if mobile.String != "" {
+		releaseMobiles(pm, []mobileClaim{{mobile: mobile.String, ref: StudentRef{Partition: partition.Name, ID: student.ID}}})
+	}
+	return nil
+}
+
+// getTranscript fetches every course a student has enrolled in or is waitlisted for,
+// ordered by term start date and course code. Waitlist entries are dated by when the
+// student joined the waitlist.
+func getTranscript(student Student) ([]Enrollment, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition, err := pm.partitionFor(student.Name)
+	if err != nil {
+		return nil, err
+	}
+	query := `SELECT e.course_code, e.term_code, e.date_enrolled, e.final_grade, 'enrolled', t.start_date
+			FROM enrollment AS e
+				JOIN terms AS t ON e.term_code = t.code
+			WHERE e.student_id = ?
+			UNION ALL
+			SELECT w.course_code, w.term_code, w.date_added, NULL, 'waitlisted', t.start_date
+			FROM waitlist AS w
+				JOIN terms AS t ON w.term_code = t.code
+			WHERE w.student_id = ?
+			ORDER BY 6, 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := partition.queryReader(ctx, query, student.ID, student.ID)
+	if err != nil {
+		return nil, wrapError(partition, "get transcript", err)
+	}
+	defer rows.Close()
+
+	var enrollments []Enrollment
+	for rows.Next() {
+		e := Enrollment{StudentID: student.ID}
+		var enrolled, start int64
+		var grade sql.NullString
+		err := rows.Scan(&e.CourseCode, &e.TermCode, &enrolled, &grade, &e.Status, &start)
+		if err != nil {
+			return nil, wrapError(partition, "get transcript", err)
+		}
+		e.DateEnrolled = time.Unix(enrolled, 0).UTC()
+		e.FinalGrade = grade.String
+		enrollments = append(enrollments, e)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, wrapError(partition, "get transcript", err)
+	}
+
+	return enrollments, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestStudentsSharingAName
+// Run sub test:  	go test -run TestStudentsSharingAName/TestCoursesAreNotMerged
+func TestStudentsSharingAName(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	db := addTestCourse(t, Course{"DB101", "Databases 101", 0})
+	algo := addTestCourse(t, Course{"ALGO201", "Algorithms 201", 0})
+	students, err := addStudents([]Student{{Name: "Ken Thompson", Mobile: "8885551112"}, {Name: "Ken Thompson", Mobile: "8885550000"}})
+	if err != nil {
+		t.Fatalf("Expected students to be added, received error: %v", err)
+	}
+	if _, err := enrollStudent(students[0], testTerm, []Course{db}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enrollStudent(students[1], testTerm, []Course{algo}); err != nil {
+		t.Fatal(err)
+	}
+
+	// TESTS //
+	t.Run("TestNameLookupReturnsCandidates", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		matches, err := getStudentsByName("Ken Thompson")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 2 || matches[0].Student != students[0] || matches[1].Student != students[1] {
+			t.Fatalf("Expected both students, received: %+v", matches)
+		}
+		if matches[1].Ref() != (StudentRef{"enrollment1.db", students[1].ID}) {
+			t.Errorf("Expected the candidate's ref, received: %v", matches[1].Ref())
+		}
+
+		_, err = findStudentByName("Ken Thompson")
+		var ambiguous *AmbiguousStudentError
+		if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 2 {
+			t.Errorf("Expected an *AmbiguousStudentError with both students, received: %v", err)
+		}
+		if matches, err := getStudentsByName("  Ken   Thompson "); err != nil || len(matches) != 2 {
+			t.Errorf("Expected both students when the name has extra whitespace, received: %+v, error: %v", matches, err)
+		}
+		if _, err := findStudentByName("Kenneth Thompson"); err == nil {
+			t.Error("Expected an unknown name to be an error, received nil")
+		}
+	})
+
+	t.Run("TestCoursesAreNotMerged", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		for i, want := range []string{"DB101", "ALGO201"} {
+			courses, err := getCourses(studentRefOf(students[i]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(courses) != 1 || courses[0].CourseCode != want {
+				t.Errorf("Expected only %s for student %d, received: %+v", want, students[i].ID, courses)
+			}
+
+			courses, err = getCoursesForTerm(studentRefOf(students[i]), testTerm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(courses) != 1 || courses[0].CourseCode != want {
+				t.Errorf("Expected only %s in %s for student %d, received: %+v", want, testTerm.Code, students[i].ID, courses)
+			}
+		}
+		if _, err := getCourses(StudentRef{"enrollment9.db", 1}); err == nil {
+			t.Error("Expected an unknown partition to be an error, received nil")
+		}
+	})
+
+	t.Run("TestGetStudentByRef", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		ref, ok := parseStudentRef(studentRefOf(students[1]).String())
+		if !ok {
+			t.Fatalf("Expected %s to parse", studentRefOf(students[1]))
+		}
+		s, err := getStudent(ref)
+		if err != nil || s != students[1] {
+			t.Errorf("Expected %+v, received: %+v, error: %v", students[1], s, err)
+		}
+		if _, err := getStudent(StudentRef{"enrollment1.db", 99}); err == nil {
+			t.Error("Expected an unknown id to be an error, received nil")
+		}
+
+		for _, s := range []string{"Ken Thompson", "enrollment1.db/", "/1", "enrollment1.db/0", "enrollment1.db/x"} {
+			if ref, ok := parseStudentRef(s); ok {
+				t.Errorf("Expected %q not to parse, received: %v", s, ref)
+			}
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// addTerm inserts a new term into every database partition and returns the created term.
+// The term is normalized first, see normalizeTerm. An add that failed part way through
+// can be retried, see addReplicated.
+func addTerm(term Term) (Term, error) {
+	term, err := normalizeTerm(term)
+	if err != nil {
+		return Term{}, err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	// like courses, terms are replicated to each db
+	err = addReplicated(pm, "add term", replicatedRow{
+		name:    term.Code,
+		table:   "terms",
+		columns: []string{"code", "name", "start_date", "end_date"},
+		key:     []string{"code"},
+		values:  []interface{}{term.Code, term.Name, term.StartDate.Unix(), term.EndDate.Unix()},
+		same: func(ctx context.Context, partition *Database, tx *sql.Tx) (bool, error) {
+			var name string
+			var start, end int64
+			err := tx.QueryRowContext(ctx, partition.rebind(`SELECT name, start_date, end_date FROM terms WHERE code = ?`),
+				term.Code).Scan(&name, &start, &end)
+			if err == sql.ErrNoRows {
+				return false, nil
+			}
+			return name == term.Name && start == term.StartDate.Unix() && end == term.EndDate.Unix(), err
+		},
+	})
+	if err != nil {
+		return Term{}, err
+	}
+	return term, nil
+}
+
+// getTerms fetches all terms ordered by start date. Terms are replicated, so
+// they are read from the first partition.
+func getTerms() ([]Term, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := `SELECT code, name, start_date, end_date
+			FROM terms
+			ORDER BY start_date`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := pm.DBs[0].queryReader(ctx, query)
+	if err != nil {
+		return nil, wrapError(pm.DBs[0], "get terms", err)
+	}
+	defer rows.Close()
+
+	var terms []Term
+	for rows.Next() {
+		t := Term{}
+		var start, end int64
+		err := rows.Scan(&t.Code, &t.Name, &start, &end)
+		if err != nil {
+			return nil, wrapError(pm.DBs[0], "get terms", err)
+		}
+		t.StartDate = time.Unix(start, 0).UTC()
+		t.EndDate = time.Unix(end, 0).UTC()
+		terms = append(terms, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, wrapError(pm.DBs[0], "get terms", err)
+	}
+
+	return terms, nil
+}
+
+// addCourseOffering offers a course in a term. Offerings are written to every partition.
+// The course code is normalized first, see normalizeCourseCode, and both the term and
+// the course must exist. An offering that failed part way through can be retried, see
+// addReplicated.
+func addCourseOffering(term Term, course Course) (CourseOffering, error) {
+	errs := &ValidationError{}
+	code, err := normalizeCourseCode(course.CourseCode)
+	if err != nil {
+		errs.add("course_code", "%v", err)
+	}
+	course.CourseCode = code
+	term.Code = strings.TrimSpace(term.Code)
+	if term.Code == "" {
+		errs.add("term_code", "must not be empty")
+	}
+	if err := errs.err(); err != nil {
+		return CourseOffering{}, err
+	}
+
+	pm, release := topology.Acquire()
+	defer release()
+
+	err = addReplicated(pm, "offer course", replicatedRow{
+		name:    fmt.Sprintf("offering of %s in %s", course.CourseCode, term.Code),
+		table:   "course_offerings",
+		columns: []string{"term_code", "course_code"},
+		key:     []string{"term_code", "course_code"},
+		values:  []interface{}{term.Code, course.CourseCode},
+		check: func(ctx context.Context, partition *Database, tx *sql.Tx) error {
+			exists := []struct{ query, what, code string }{
+				{`SELECT COUNT(*) FROM terms WHERE code = ?`, "term", term.Code},
+				{`SELECT COUNT(*) FROM courses WHERE code = ?`, "course", course.CourseCode},
+			}
+			for _, e := range exists {
+				var n int
+				if err := tx.QueryRowContext(ctx, partition.rebind(e.query), e.code).Scan(&n); err != nil {
+					return err
+				}
+				if n == 0 {
+					return kindErrorf(ErrNotFound, "Unable to offer course: %s %s does not exist", e.what, e.code)
+				}
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return CourseOffering{}, err
+	}
+	return CourseOffering{TermCode: term.Code, CourseCode: course.CourseCode}, nil
+}
+
+// getCourseOfferings fetches the courses offered in a term.
+func getCourseOfferings(term Term) ([]Course, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	query := `SELECT c.code, c.name, c.capacity
+			FROM course_offerings AS o
+				JOIN courses AS c ON o.course_code = c.code
+			WHERE o.term_code = ?
+			ORDER BY c.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	courses, err := execGetCoursesSql(ctx, pm.DBs[0], query, term.Code)
+	return courses, wrapError(pm.DBs[0], "get course offerings", err)
+}
+
+// getCoursesForTerm fetches the courses a student is taking in a term. Like getCourses,
+// the student is identified by ref, since students can share a name.
+func getCoursesForTerm(ref StudentRef, term Term) ([]Course, error) {
+	pm, release := topology.Acquire()
+	defer release()
+
+	partition := pm.GetDatabaseByName(ref.Partition)
+	if partition == nil {
+		return nil, kindErrorf(ErrNotFound, "Unable to get courses for student %s: no partition named %s", ref, ref.Partition)
+	}
+	query := `SELECT c.code, c.name, c.capacity
+			FROM enrollment AS e
+				JOIN courses AS c ON e.course_code = c.code
+			WHERE e.student_id = ? AND e.term_code = ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	courses, err := execGetCoursesSql(ctx, partition, query, ref.ID, term.Code)
+	return courses, wrapError(partition, "get courses", err)
+}
+
+// checkCourseOfferings verifies that every provided course is offered in the term. It
+// fails with ErrNotFound if any is not.
+func checkCourseOfferings(ctx context.Context, partition *Database, term Term, courses []Course) error {
+	query := `SELECT 1 FROM course_offerings WHERE term_code = ? AND course_code = ?`
+
+	var notOffered []string
+	for i := range courses {
+		var found int
+		err := partition.withBreaker(func() error {
+			return partition.primaryReader().QueryRowContext(ctx, partition.rebind(query), term.Code, courses[i].CourseCode).Scan(&found)
+		})
+		if err == sql.ErrNoRows {
+			notOffered = append(notOffered, courses[i].CourseCode)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(notOffered) > 0 {
+		return kindErrorf(ErrNotFound, "Unable to enroll student: %s not offered in %s", strings.Join(notOffered, ", "), term.Code)
+	}
+	return nil
+}
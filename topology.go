@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Topology holds the partition manager in use and swaps it for a new one when the
+// partition layout changes, without restarting the app. Operations acquire the
+// current manager for their whole duration, so an operation that started before a
+// swap finishes on the old manager, and the old manager's connections are only
+// closed once every such operation has released it.
+//
+// Reloading changes where new queries are routed; it does not move existing rows
+// between partitions. Operators are responsible for migrating data before changing
+// the key ranges of partitions that already hold students.
+type Topology struct {
+	mu         sync.Mutex
+	current    *topologyGeneration
+	configPath string
+	// draining counts the previous generations whose connections are not closed yet.
+	draining sync.WaitGroup
+}
+
+// topologyGeneration is one partition manager and the operations still using it.
+type topologyGeneration struct {
+	pm       *PartitionManager
+	inFlight sync.WaitGroup
+}
+
+// NewTopology starts a topology with pm as the current partition manager. configPath
+// is the partition config file that Reload and Watch read; it may be empty if the
+// topology is only changed through Swap.
+func NewTopology(pm *PartitionManager, configPath string) *Topology {
+	return &Topology{
+		current:    &topologyGeneration{pm: pm},
+		configPath: configPath,
+	}
+}
+
+// Acquire returns the current partition manager and a release func that must be
+// called when the caller is done with it.
+func (t *Topology) Acquire() (*PartitionManager, func()) {
+	t.mu.Lock()
+	gen := t.current
+	gen.inFlight.Add(1)
+	t.mu.Unlock()
+
+	var once sync.Once
+	return gen.pm, func() {
+		once.Do(gen.inFlight.Done)
+	}
+}
+
+// Swap makes pm the current partition manager. The previous manager's connections
+// are closed in the background once the operations using it have finished; the
+// returned channel is closed when that has happened.
+func (t *Topology) Swap(pm *PartitionManager) <-chan struct{} {
+	t.mu.Lock()
+	old := t.current
+	t.current = &topologyGeneration{pm: pm}
+	t.draining.Add(1)
+	t.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		defer t.draining.Done()
+		old.inFlight.Wait()
+		old.pm.CloseConnections()
+		log.Println("Closed connections for previous partition topology")
+		close(drained)
+	}()
+
+	return drained
+}
+
+// Reload reads the partition config file, opens the partitions it defines and swaps
+// them in. If the config is invalid, a partition cannot be opened or the config
+// names a different coordinator partition, the current topology is left in place and
+// the error is returned. The coordinator's DSN may change, e.g. to rotate its
+// credentials or move its file, as long as it still holds the coordinator's tables.
+func (t *Topology) Reload() error {
+	dbs, err := createDBPartitions(t.configPath)
+	if err != nil {
+		return err
+	}
+
+	pm, err := NewPartitionManager(dbs)
+	if err != nil {
+		closeDatabases(dbs)
+		return err
+	}
+
+	// the seat counters and mobile index are not moved, so the coordinator role must
+	// stay with the same partition
+	current, release := t.Acquire()
+	from, to := current.GetSeatCounterDatabase(), pm.GetSeatCounterDatabase()
+	release()
+	if from.Name != to.Name {
+		pm.CloseConnections()
+		return fmt.Errorf("Unable to reload partition topology: coordinator partition changed from %s to %s", from.Name, to.Name)
+	}
+
+	// make sure every new partition is reachable before routing queries to it
+	for _, db := range pm.DBs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := db.db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			pm.CloseConnections()
+			return err
+		}
+	}
+
+	pm.StartHealthChecks(DefaultHealthCheckConfig)
+	t.Swap(&pm)
+	log.Printf("Reloaded partition topology from %s:\n%s", t.configPath, pm.Describe())
+
+	return nil
+}
+
+// Watch reloads the topology when the process receives SIGHUP or when the config
+// file's modification time changes, checking the file every interval. It blocks
+// until ctx is done.
+func (t *Topology) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastMod := t.configModTime()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("Received SIGHUP, reloading partition topology...")
+		case <-ticker.C:
+			mod := t.configModTime()
+			if mod.Equal(lastMod) {
+				continue
+			}
+			log.Printf("Partition config %s changed, reloading partition topology...", t.configPath)
+		}
+
+		lastMod = t.configModTime()
+		if err := t.Reload(); err != nil {
+			log.Printf("Unable to reload partition topology, keeping current topology: %v", err)
+		}
+	}
+}
+
+// configModTime returns the modification time of the config file, or the zero time
+// if it cannot be read.
+func (t *Topology) configModTime() time.Time {
+	info, err := os.Stat(t.configPath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Close waits for in-flight operations to finish and closes the current
+// partition manager's connections, then waits for every previous generation that is
+// still draining to be closed.
+func (t *Topology) Close() {
+	t.mu.Lock()
+	gen := t.current
+	t.mu.Unlock()
+
+	gen.inFlight.Wait()
+	gen.pm.CloseConnections()
+	t.draining.Wait()
+}
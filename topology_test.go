@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestTopology
+// Run sub test:  	go test -run TestTopology/TestSwapDrainsInFlight
+func TestTopology(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	dir, err := ioutil.TempDir("", "enrollment")
+	if err != nil {
+		t.Fatalf("Expected to create temp dir, received error: %v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "partitions.json")
+	writeConfig := func(layout string) {
+		if err := ioutil.WriteFile(configPath, []byte(layout), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig(fmt.Sprintf(`{"partitions": [{"name": "all.db", "dsn": %q, "key_range": {"start": "A", "end": "Z"}}]}`,
+		filepath.Join(dir, "all.db")))
+
+	dbs, err := createDBPartitions(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm, err := NewPartitionManager(dbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top := NewTopology(&pm, configPath)
+
+	// TESTS //
+	t.Run("TestSwapDrainsInFlight", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		old, release := top.Acquire()
+
+		writeConfig(fmt.Sprintf(`{"partitions": [
+			{"name": "all.db", "dsn": %q, "key_range": {"start": "A", "end": "M"}},
+			{"name": "second.db", "dsn": %q, "key_range": {"start": "N", "end": "Z"}}
+		]}`, filepath.Join(dir, "all.db"), filepath.Join(dir, "second.db")))
+		if err := top.Reload(); err != nil {
+			t.Fatalf("Expected topology to reload, received error: %v", err)
+		}
+
+		cur, releaseCur := top.Acquire()
+		defer releaseCur()
+		if len(cur.DBs) != 2 {
+			t.Errorf("Expected new topology with 2 partitions, received %d", len(cur.DBs))
+		}
+
+		// the operation that started before the reload can still use the old partitions
+		if err := old.DBs[0].db.Ping(); err != nil {
+			t.Errorf("Expected old partition to stay open while in use, ping failed: %v", err)
+		}
+		release()
+	})
+
+	t.Run("TestReloadKeepsTopologyOnInvalidConfig", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		writeConfig(fmt.Sprintf(`{"partitions": [{"name": "gap.db", "dsn": %q, "key_range": {"start": "A", "end": "M"}}]}`,
+			filepath.Join(dir, "gap.db")))
+		if err := top.Reload(); err == nil {
+			t.Fatal("Expected reload to fail for a layout with a gap, received nil")
+		}
+
+		cur, release := top.Acquire()
+		defer release()
+		if len(cur.DBs) != 2 {
+			t.Errorf("Expected current topology to be kept, received %d partitions", len(cur.DBs))
+		}
+	})
+
+	t.Run("TestReloadRejectsCoordinatorChange", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		writeConfig(fmt.Sprintf(`{"coordinator_partition": "second.db", "partitions": [
+			{"name": "all.db", "dsn": %q, "key_range": {"start": "A", "end": "M"}},
+			{"name": "second.db", "dsn": %q, "key_range": {"start": "N", "end": "Z"}}
+		]}`, filepath.Join(dir, "all.db"), filepath.Join(dir, "second.db")))
+
+		dbs, err := createDBPartitions(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinned, err := NewPartitionManager(dbs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned.GetSeatCounterDatabase().Name != "second.db" || pinned.GetIndexDatabase().Name != "second.db" {
+			t.Errorf("Expected the configured coordinator partition, received %s", pinned.GetSeatCounterDatabase().Name)
+		}
+		pinned.CloseConnections()
+
+		if err := top.Reload(); err == nil || !strings.Contains(err.Error(), "coordinator partition changed from all.db to second.db") {
+			t.Fatalf("Expected reload to be rejected, received: %v", err)
+		}
+		cur, release := top.Acquire()
+		defer release()
+		if cur.GetSeatCounterDatabase().Name != "all.db" {
+			t.Errorf("Expected current topology to be kept, received coordinator %s", cur.GetSeatCounterDatabase().Name)
+		}
+	})
+
+	t.Run("TestReloadAllowsCoordinatorDSNChange", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		writeConfig(fmt.Sprintf(`{"partitions": [
+			{"name": "all.db", "dsn": %q, "key_range": {"start": "A", "end": "M"}},
+			{"name": "second.db", "dsn": %q, "key_range": {"start": "N", "end": "Z"}}
+		]}`, DSNWithBusyTimeout(filepath.Join(dir, "all.db"), time.Second), filepath.Join(dir, "second.db")))
+		if err := top.Reload(); err != nil {
+			t.Fatalf("Expected reload with a new coordinator DSN to succeed, received error: %v", err)
+		}
+
+		cur, release := top.Acquire()
+		defer release()
+		if coordinator := cur.GetSeatCounterDatabase(); coordinator.Name != "all.db" || !strings.Contains(coordinator.dsn, "busy_timeout") {
+			t.Errorf("Expected all.db with the new DSN to be the coordinator, received %s: %s", coordinator.Name, coordinator.dsn)
+		}
+	})
+
+	t.Run("TestSwapClosesOldAfterRelease", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		old, release := top.Acquire()
+
+		next, err := NewPartitionManager(old.DBs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drained := top.Swap(&next)
+
+		select {
+		case <-drained:
+			t.Fatal("Expected old topology to stay open until released")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		release()
+		select {
+		case <-drained:
+		case <-time.After(time.Second):
+			t.Fatal("Expected old topology to be closed after release")
+		}
+	})
+
+	t.Run("TestCloseWaitsForEveryGeneration", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		openManager := func(name string) *PartitionManager {
+			db, err := NewDatabase(name, filepath.Join(dir, name), 'A', 'Z')
+			if err != nil {
+				t.Fatal(err)
+			}
+			pm, err := NewPartitionManager([]*Database{db})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return &pm
+		}
+
+		gens := NewTopology(openManager("gen1.db"), "")
+		first, releaseFirst := gens.Acquire()
+		gens.Swap(openManager("gen2.db"))
+		second, releaseSecond := gens.Acquire()
+		gens.Swap(openManager("gen3.db"))
+
+		closed := make(chan struct{})
+		go func() {
+			gens.Close()
+			close(closed)
+		}()
+
+		releaseSecond()
+		select {
+		case <-closed:
+			t.Fatal("Expected Close to wait for the first generation to drain")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err := first.DBs[0].db.Ping(); err != nil {
+			t.Errorf("Expected the first generation to stay open while in use, ping failed: %v", err)
+		}
+
+		releaseFirst()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("Expected Close to return once every generation drained")
+		}
+		for _, pm := range []*PartitionManager{first, second} {
+			if err := pm.DBs[0].db.Ping(); err == nil {
+				t.Errorf("Expected %s to be closed", pm.DBs[0].Name)
+			}
+		}
+	})
+
+	// TEST TEAR DOWN //
+	top.Close()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Every write validates and normalizes the values it is given before any partition is
+// written, and reports every invalid field at once in a *ValidationError.
+
+// FieldError describes why the value of a field is invalid. Field is the field's JSON
+// name, prefixed with its position when a write takes several values, e.g.
+// students[1].mobile.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of the values given to a write.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = fmt.Sprintf("%s %s", f.Field, f.Message)
+	}
+	return fmt.Sprintf("Invalid input: %s", strings.Join(msgs, "; "))
+}
+
+// add records that field is invalid.
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// addAll records the invalid fields of err, a *ValidationError for the value at
+// prefix, e.g. students[1], or for the same value if prefix is empty. Other errors
+// are recorded against prefix itself.
+func (e *ValidationError) addAll(prefix string, err error) {
+	v, ok := err.(*ValidationError)
+	if !ok {
+		e.add(prefix, "%v", err)
+		return
+	}
+	for _, f := range v.Fields {
+		field := f.Field
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		e.add(field, "%s", f.Message)
+	}
+}
+
+// err returns e if any field is invalid, and nil otherwise.
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// maxNameLength is the longest student or course name, in characters.
+const maxNameLength = 100
+
+// defaultCountryCode is assumed for 10 digit mobile numbers without a country code,
+// like the sample data's.
+const defaultCountryCode = "1"
+
+var (
+	// e164Pattern matches a normalized mobile number: + and a country code followed
+	// by up to 15 digits in all.
+	e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	// courseCodePattern matches a course code: a subject of 2 to 6 letters, a 3 digit
+	// number and an optional letter, e.g. DB101 or ALGO201.
+	courseCodePattern = regexp.MustCompile(`^[A-Z]{2,6}[0-9]{3}[A-Z]?$`)
+)
+
+// finalGrades are the grades a student can receive in a course.
+var finalGrades = append(append([]string{}, passingGrades...), "F")
+
+// normalizeStudent trims the student's name, collapsing runs of spaces, and normalizes
+// their mobile number to E.164 (see normalizeMobile). A name must start with a letter
+// from A to Z, since students are partitioned by the first letter of their name. A
+// student without a mobile number is allowed.
+func normalizeStudent(s Student) (Student, error) {
+	errs := &ValidationError{}
+
+	s.Name = strings.Join(strings.Fields(s.Name), " ")
+	switch {
+	case s.Name == "":
+		errs.add("name", "must not be empty")
+	case len([]rune(s.Name)) > maxNameLength:
+		errs.add("name", "must be at most %d characters", maxNameLength)
+	case !isPartitionKeyLetter(s.Name[0]):
+		errs.add("name", "must start with a letter from A to Z")
+	}
+
+	if strings.TrimSpace(s.Mobile) == "" {
+		s.Mobile = ""
+	} else if mobile, err := normalizeMobile(s.Mobile); err != nil {
+		errs.add("mobile", "%v", err)
+	} else {
+		s.Mobile = mobile
+	}
+
+	return s, errs.err()
+}
+
+// isPartitionKeyLetter reports whether c is a letter in the partition key space,
+// ignoring case.
+func isPartitionKeyLetter(c byte) bool {
+	upper := rune(strings.ToUpper(string(c))[0])
+	return upper >= partitionKeySpaceStart && upper <= partitionKeySpaceEnd
+}
+
+// normalizeMobile normalizes a mobile number to E.164: + and the country code followed
+// by the number, without separators, e.g. +18885551111. Spaces, dashes, dots and
+// parentheses are dropped. A number without a + may start with the international
+// prefix 00, or be a 10 digit number in defaultCountryCode.
+func normalizeMobile(mobile string) (string, error) {
+	var digits strings.Builder
+	plus := false
+	for i, r := range strings.TrimSpace(mobile) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			plus = true
+		case strings.ContainsRune(" -.()", r):
+		default:
+			return "", fmt.Errorf("must contain only digits, spaces, dashes, dots and parentheses, after an optional +")
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case plus:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case len(number) == 10:
+		number = defaultCountryCode + number
+	default:
+		return "", fmt.Errorf("must start with + and the country code")
+	}
+
+	number = "+" + number
+	if !e164Pattern.MatchString(number) {
+		return "", fmt.Errorf("must be + and a country code followed by up to 15 digits in all")
+	}
+	return number, nil
+}
+
+// normalizeCourseCode trims a course code and converts it to upper case.
+func normalizeCourseCode(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !courseCodePattern.MatchString(code) {
+		return code, fmt.Errorf("must be 2 to 6 letters and 3 digits, e.g. DB101")
+	}
+	return code, nil
+}
+
+// normalizeCourse normalizes the course code (see normalizeCourseCode) and trims the
+// course name.
+func normalizeCourse(c Course) (Course, error) {
+	errs := &ValidationError{}
+
+	code, err := normalizeCourseCode(c.CourseCode)
+	if err != nil {
+		errs.add("code", "%v", err)
+	}
+	c.CourseCode = code
+
+	c.Name = strings.Join(strings.Fields(c.Name), " ")
+	switch {
+	case c.Name == "":
+		errs.add("name", "must not be empty")
+	case len([]rune(c.Name)) > maxNameLength:
+		errs.add("name", "must be at most %d characters", maxNameLength)
+	}
+
+	if c.Capacity < 0 {
+		errs.add("capacity", "must not be negative")
+	}
+
+	return c, errs.err()
+}
+
+// normalizeTerm trims the term code and the term name. The term must end after it
+// starts.
+func normalizeTerm(t Term) (Term, error) {
+	errs := &ValidationError{}
+
+	t.Code = strings.TrimSpace(t.Code)
+	if t.Code == "" {
+		errs.add("code", "must not be empty")
+	}
+
+	t.Name = strings.Join(strings.Fields(t.Name), " ")
+	switch {
+	case t.Name == "":
+		errs.add("name", "must not be empty")
+	case len([]rune(t.Name)) > maxNameLength:
+		errs.add("name", "must be at most %d characters", maxNameLength)
+	}
+
+	switch {
+	case t.StartDate.IsZero():
+		errs.add("start_date", "must be set")
+	case t.EndDate.IsZero():
+		errs.add("end_date", "must be set")
+	case !t.EndDate.After(t.StartDate):
+		errs.add("end_date", "must be after start_date")
+	}
+
+	return t, errs.err()
+}
+
+// normalizeEnrollment normalizes the course code and trims the term code and final
+// grade of an enrollment. The grade, if set, must be one of finalGrades.
+func normalizeEnrollment(e Enrollment) (Enrollment, error) {
+	errs := &ValidationError{}
+
+	if e.StudentID == 0 {
+		errs.add("student_id", "must be set")
+	}
+
+	code, err := normalizeCourseCode(e.CourseCode)
+	if err != nil {
+		errs.add("course_code", "%v", err)
+	}
+	e.CourseCode = code
+
+	e.TermCode = strings.TrimSpace(e.TermCode)
+	if e.TermCode == "" {
+		errs.add("term_code", "must not be empty")
+	}
+
+	e.FinalGrade = strings.ToUpper(strings.TrimSpace(e.FinalGrade))
+	if e.FinalGrade != "" && !containsString(finalGrades, e.FinalGrade) {
+		errs.add("final_grade", "must be one of %s", strings.Join(finalGrades, ", "))
+	}
+
+	switch e.Status {
+	case "", EnrollmentStatusEnrolled, EnrollmentStatusWaitlisted:
+	default:
+		errs.add("status", "must be %s or %s", EnrollmentStatusEnrolled, EnrollmentStatusWaitlisted)
+	}
+
+	return e, errs.err()
+}
+
+// containsString reports whether values contains v.
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
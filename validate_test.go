@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TO EXECUTE TESTS:
+// Run test group: 	go test -run TestValidation
+// Run sub test:  	go test -run TestValidation/TestNormalizeMobile
+func TestValidation(t *testing.T) {
+	fmt.Printf("Running test group: %s\n", t.Name())
+
+	// TEST SET UP //
+	teardown := setupTestPartitions(t)
+	defer teardown()
+
+	fields := func(err error) []string {
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			return nil
+		}
+		var s []string
+		for _, f := range invalid.Fields {
+			s = append(s, f.Field)
+		}
+		return s
+	}
+
+	// TESTS //
+	t.Run("TestNormalizeMobile", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		tests := []struct {
+			mobile string
+			want   string
+		}{
+			{"8885551111", "+18885551111"},
+			{"(888) 555-1111", "+18885551111"},
+			{"+44 20 7946 0958", "+442079460958"},
+			{"0044 20 7946 0958", "+442079460958"},
+			{"5551111", ""},
+			{"+0 888 555 1111", ""},
+			{"888-555-1111 x2", ""},
+			{"88+85551111", ""},
+		}
+		for _, tt := range tests {
+			got, err := normalizeMobile(tt.mobile)
+			if tt.want == "" && err == nil {
+				t.Errorf("Expected %q to be rejected, received: %s", tt.mobile, got)
+			}
+			if tt.want != "" && (err != nil || got != tt.want) {
+				t.Errorf("Expected %q to be normalized to %s, received: %s, error: %v", tt.mobile, tt.want, got, err)
+			}
+		}
+	})
+
+	t.Run("TestNormalizeStudent", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		s, err := normalizeStudent(Student{Name: "  Ken   Thompson ", Mobile: "888.555.1112"})
+		if err != nil || s.Name != "Ken Thompson" || s.Mobile != "+18885551112" {
+			t.Errorf("Expected the student to be normalized, received: %+v, error: %v", s, err)
+		}
+
+		_, err = normalizeStudent(Student{Name: "1st Student", Mobile: "555"})
+		if want := "[name mobile]"; fmt.Sprint(fields(err)) != want {
+			t.Errorf("Expected invalid fields %s, received: %v", want, err)
+		}
+	})
+
+	t.Run("TestBatchReportsEveryField", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		_, err := addStudents([]Student{{Name: "Ken Thompson", Mobile: "8885551112"}, {Name: "", Mobile: "555"}})
+		if want := "[students[1].name students[1].mobile]"; fmt.Sprint(fields(err)) != want {
+			t.Errorf("Expected invalid fields %s, received: %v", want, err)
+		}
+		// nothing in the batch is written
+		if matches, err := searchStudentsByMobile("8885551112"); err != nil || len(matches) != 0 {
+			t.Errorf("Expected no student to be added, received: %+v, error: %v", matches, err)
+		}
+
+		_, err = addCourse(Course{"db 101", "", -1})
+		if want := "[code name capacity]"; fmt.Sprint(fields(err)) != want {
+			t.Errorf("Expected invalid fields %s, received: %v", want, err)
+		}
+	})
+
+	t.Run("TestAddStudentsStoresNormalizedValues", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		added, err := addStudents([]Student{{Name: " Ken  Thompson", Mobile: "(888) 555-1112"}, {Name: "Rob Pike", Mobile: "888.555.1111"}})
+		if err != nil {
+			t.Fatalf("Expected students to be added, received error: %v", err)
+		}
+		want := []Student{{Name: "Ken Thompson", Mobile: "+18885551112"}, {Name: "Rob Pike", Mobile: "+18885551111"}}
+		for i, s := range added {
+			stored, err := getStudent(studentRefOf(s))
+			if err != nil || stored.Name != want[i].Name || stored.Mobile != want[i].Mobile {
+				t.Errorf("Expected %+v to be stored, received: %+v, error: %v", want[i], stored, err)
+			}
+		}
+	})
+
+	t.Run("TestTermIsNormalized", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		term, err := normalizeTerm(Term{Code: " 2022SP ", Name: "Spring  2022", StartDate: testTerm.StartDate, EndDate: testTerm.EndDate})
+		if err != nil || term.Code != "2022SP" || term.Name != "Spring 2022" {
+			t.Errorf("Expected the term to be normalized, received: %+v, error: %v", term, err)
+		}
+
+		_, err = normalizeTerm(Term{Code: " ", StartDate: testTerm.EndDate, EndDate: testTerm.StartDate})
+		if want := "[code name end_date]"; fmt.Sprint(fields(err)) != want {
+			t.Errorf("Expected invalid fields %s, received: %v", want, err)
+		}
+	})
+
+	t.Run("TestEnrollmentIsNormalized", func(t *testing.T) {
+		fmt.Printf("Running test: %s\n", t.Name())
+		e, err := normalizeEnrollment(Enrollment{StudentID: 1, CourseCode: " db101 ", TermCode: testTerm.Code, FinalGrade: "b+"})
+		if err != nil || e.CourseCode != "DB101" || e.FinalGrade != "B+" {
+			t.Errorf("Expected the enrollment to be normalized, received: %+v, error: %v", e, err)
+		}
+
+		_, err = normalizeEnrollment(Enrollment{CourseCode: "DB101", TermCode: " ", FinalGrade: "E"})
+		if want := "[student_id term_code final_grade]"; fmt.Sprint(fields(err)) != want {
+			t.Errorf("Expected invalid fields %s, received: %v", want, err)
+		}
+
+		// codes are normalized when reading and withdrawing, as when enrolling
+		course := addTestCourse(t, Course{CourseCode: "DB101", Name: "Databases 101"})
+		added, err := addStudents([]Student{{Name: "Dennis Ritchie"}})
+		if err != nil {
+			t.Fatalf("Expected student to be added, received error: %v", err)
+		}
+		if _, err := enrollStudent(added[0], testTerm, []Course{course}); err != nil {
+			t.Fatalf("Expected student to be enrolled, received error: %v", err)
+		}
+		roster, err := getCourseRoster(" db101", " "+testTerm.Code+" ", time.Hour)
+		if err != nil || len(roster.Entries) != 1 {
+			t.Errorf("Expected 1 student in the roster of db101, received: %+v, error: %v", roster.Entries, err)
+		}
+		records, err := getEnrollmentsBetween(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), "db101", 0)
+		if err != nil || len(records) != 1 {
+			t.Errorf("Expected 1 enrollment in db101, received: %+v, error: %v", records, err)
+		}
+		if err := withdrawStudent(added[0], Term{Code: testTerm.Code + " "}, Course{CourseCode: "db101"}); err != nil {
+			t.Errorf("Expected student to be withdrawn from db101, received error: %v", err)
+		}
+		roster, err = getCourseRoster("DB101", testTerm.Code, time.Hour)
+		if err != nil || len(roster.Entries) != 0 {
+			t.Errorf("Expected no students in the roster of DB101, received: %+v, error: %v", roster.Entries, err)
+		}
+	})
+
+	// TEST TEAR DOWN //
+}
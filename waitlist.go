@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+)
+
+// Enrollments are spread across every partition, so counting the seats taken in a
+// course would mean querying every partition on each enrollment and would race with
+// concurrent enrollments in other partitions. Instead, each capacity-limited course
+// has a single seat counter per term stored in the seat counter partition (see
+// GetSeatCounterDatabase). A seat is reserved in the counter before the enrollment is
+// written to the student's partition, and released again if that write fails. A
+// counter is created the first time a seat in the course is reserved for the term,
+// starting at the number of students already enrolled in every partition.
+
+// partitionWaitlistEntry pairs a waitlist entry with the partition it is stored in;
+// student ids are only unique within a partition.
+type partitionWaitlistEntry struct {
+	WaitlistEntry
+	partition *Database
+}
+
+// getCourseCapacities fetches the capacity of each provided course from the
+// replicated courses table in partition. Courses without a limit map to zero.
+func getCourseCapacities(ctx context.Context, partition *Database, courses []Course) (map[string]int, error) {
+	query := `SELECT capacity FROM courses WHERE code = ?`
+
+	capacities := make(map[string]int, len(courses))
+	for i := range courses {
+		var capacity sql.NullInt64
+		err := partition.withBreaker(func() error {
+			return partition.primaryReader().QueryRowContext(ctx, partition.rebind(query), courses[i].CourseCode).Scan(&capacity)
+		})
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		capacities[courses[i].CourseCode] = int(capacity.Int64)
+	}
+
+	return capacities, nil
+}
+
+// seedSeatCounter creates the seat counter for a course offered in a term if it does
+// not exist yet, starting it at the number of students enrolled in every partition,
+// e.g. enrollments written before the course had a capacity or by the build.
+func seedSeatCounter(ctx context.Context, pm *PartitionManager, termCode string, courseCode string) error {
+	counter := pm.GetSeatCounterDatabase()
+	var exists int
+	err := counter.withBreaker(func() error {
+		return counter.db.QueryRowContext(ctx, counter.rebind(`SELECT COUNT(*) FROM course_seats WHERE term_code = ? AND course_code = ?`),
+			termCode, courseCode).Scan(&exists)
+	})
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	enrolled := 0
+	for i := range pm.DBs {
+		var n int
+		err := pm.DBs[i].withBreaker(func() error {
+			return pm.DBs[i].primaryReader().QueryRowContext(ctx, pm.DBs[i].rebind(`SELECT COUNT(*) FROM enrollment WHERE term_code = ? AND course_code = ?`),
+				termCode, courseCode).Scan(&n)
+		})
+		if err != nil {
+			return wrapError(pm.DBs[i], "count enrollments", err)
+		}
+		enrolled += n
+	}
+
+	// a counter seeded concurrently by another enrollment is kept
+	insert := counter.Dialect.Upsert("course_seats", []string{"term_code", "course_code", "enrolled"},
+		[]string{"term_code", "course_code"}, nil)
+	return counter.WithTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, counter.rebind(insert), termCode, courseCode, enrolled)
+		return err
+	})
+}
+
+// reserveSeat claims a seat in a course offered in a term if fewer than capacity seats
+// are taken. It reports false, without error, when the course is full.
+func reserveSeat(ctx context.Context, pm *PartitionManager, termCode string, courseCode string, capacity int) (bool, error) {
+	if err := seedSeatCounter(ctx, pm, termCode, courseCode); err != nil {
+		return false, err
+	}
+
+	counter := pm.GetSeatCounterDatabase()
+	reserved := false
+	err := counter.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, counter.rebind(`UPDATE course_seats SET enrolled = enrolled + 1
+			WHERE term_code = ? AND course_code = ? AND enrolled < ?`), termCode, courseCode, capacity)
+		if err != nil {
+			return err
+		}
+
+		cnt, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		reserved = cnt == 1
+		return nil
+	})
+
+	return reserved, err
+}
+
+// releaseSeat gives back a seat previously claimed with reserveSeat.
+func releaseSeat(ctx context.Context, pm *PartitionManager, termCode string, courseCode string) error {
+	counter := pm.GetSeatCounterDatabase()
+	return counter.WithTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, counter.rebind(`UPDATE course_seats SET enrolled = enrolled - 1
+			WHERE term_code = ? AND course_code = ? AND enrolled > 0`), termCode, courseCode)
+		return err
+	})
+}
+
+// releaseSeats gives back seats reserved for an enrollment that could not be
+// written. It uses its own context so seats are released even when the caller's
+// context has already expired; failures are logged since the caller is already
+// returning the original error.
+func releaseSeats(pm *PartitionManager, termCode string, courseCodes []string) {
+	for _, code := range courseCodes {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := releaseSeat(ctx, pm, termCode, code); err != nil {
+			log.Printf("Unable to release seat in %s for %s: %v", code, termCode, err)
+		}
+		cancel()
+	}
+}
+
+// getWaitlist fetches the students waiting for a seat in a course offered in a term
+// from every partition, ordered by the time they were added to the waitlist.
+func getWaitlist(ctx context.Context, pm *PartitionManager, termCode string, courseCode string) ([]partitionWaitlistEntry, error) {
+	query := `SELECT student_id, course_code, term_code, date_added
+			FROM waitlist
+			WHERE term_code = ? AND course_code = ?
+			ORDER BY date_added`
+
+	var entries []partitionWaitlistEntry
+	for i := range pm.DBs {
+		rows, err := pm.DBs[i].query(ctx, query, termCode, courseCode)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e := partitionWaitlistEntry{partition: pm.DBs[i]}
+			var added int64
+			err := rows.Scan(&e.StudentID, &e.CourseCode, &e.TermCode, &added)
+			if err != nil {
+				return nil, err
+			}
+			e.DateAdded = time.Unix(added, 0).UTC()
+			entries = append(entries, e)
+		}
+		err = rows.Err()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DateAdded.Before(entries[j].DateAdded)
+	})
+
+	return entries, nil
+}
+
+// fillFreedSeat hands a seat given up in a course offered in a term to the next
+// student on the waitlist and returns their entry. If nobody is waiting, the seat is
+// returned to the counter and nil is returned.
+func fillFreedSeat(ctx context.Context, pm *PartitionManager, termCode string, courseCode string) (*partitionWaitlistEntry, error) {
+	entries, err := getWaitlist(ctx, pm, termCode, courseCode)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		promoted, err := promoteWaitlistEntry(ctx, entries[i])
+		if err != nil {
+			return nil, err
+		}
+		if promoted {
+			log.Printf("Promoted student %d in %s from the %s %s waitlist", entries[i].StudentID, entries[i].partition.Name, termCode, courseCode)
+			return &entries[i], nil
+		}
+	}
+
+	return nil, releaseSeat(ctx, pm, termCode, courseCode)
+}
+
+// offerFreeSeats hands every free seat in a course offered in a term to the students
+// on its waitlist and returns the entries promoted. It is run after a student is
+// waitlisted, since a seat freed between the capacity check and the waitlist insert
+// finds nobody waiting and is otherwise not offered until the next withdrawal.
+func offerFreeSeats(ctx context.Context, pm *PartitionManager, termCode string, courseCode string, capacity int) ([]partitionWaitlistEntry, error) {
+	var promoted []partitionWaitlistEntry
+	for {
+		ok, err := reserveSeat(ctx, pm, termCode, courseCode, capacity)
+		if err != nil || !ok {
+			return promoted, err
+		}
+		entry, err := fillFreedSeat(ctx, pm, termCode, courseCode)
+		if err != nil || entry == nil {
+			return promoted, err
+		}
+		promoted = append(promoted, *entry)
+	}
+}
+
+// promoteWaitlistEntry moves a waitlisted student into the course. It reports false
+// if the entry was already removed, e.g. by a concurrent promotion or withdrawal.
+func promoteWaitlistEntry(ctx context.Context, entry partitionWaitlistEntry) (bool, error) {
+	enrollStmt, err := entry.partition.prepared(ctx, `INSERT INTO enrollment(student_id, course_code, term_code, date_enrolled, final_grade)
+			VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return false, err
+	}
+
+	var promoted bool
+	err = entry.partition.WithTx(ctx, func(tx *sql.Tx) error {
+		promoted = false
+		res, err := tx.ExecContext(ctx, entry.partition.rebind(`DELETE FROM waitlist WHERE student_id = ? AND course_code = ? AND term_code = ?`),
+			entry.StudentID, entry.CourseCode, entry.TermCode)
+		if err != nil {
+			return err
+		}
+
+		cnt, err := res.RowsAffected()
+		if err != nil || cnt == 0 {
+			return err
+		}
+
+		now := time.Now().UTC()
+		err = execEnrollStudentSql(ctx, tx, enrollStmt, entry.StudentID, entry.CourseCode, entry.TermCode, now.Unix(), nil)
+		if err != nil {
+			return err
+		}
+		promoted = true
+		return nil
+	})
+
+	return promoted, err
+}